@@ -0,0 +1,132 @@
+package zlog
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+
+	caddy "github.com/caddyserver/caddy/v2"
+)
+
+func init() {
+	caddy.RegisterModule(adminAPI{})
+}
+
+// adminAPI 在 caddy 的 admin 接口上暴露 GET /zlog/status, 只读
+type adminAPI struct{}
+
+func (adminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.zlog",
+		New: func() caddy.Module { return new(adminAPI) },
+	}
+}
+
+func (adminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{Pattern: "/zlog/status", Handler: caddy.AdminHandlerFunc(handleStatus)},
+	}
+}
+
+// instances 所有 Provision 过还没有 Cleanup 的 ZLog
+var instances = struct {
+	sync.Mutex
+	list []*ZLog
+}{}
+
+func registerInstance(z *ZLog) {
+	instances.Lock()
+	defer instances.Unlock()
+	instances.list = append(instances.list, z)
+}
+
+func unregisterInstance(z *ZLog) {
+	instances.Lock()
+	defer instances.Unlock()
+	for i, v := range instances.list {
+		if v == z {
+			instances.list = append(instances.list[:i], instances.list[i+1:]...)
+			return
+		}
+	}
+}
+
+// RuntimeStatus 一个 zlog 实例的运行状态
+type RuntimeStatus struct {
+	RouteName      string `json:"route_name,omitempty"`
+	FileName       string `json:"file_name,omitempty"`
+	HTTPSink       string `json:"http_sink,omitempty"`
+	SyslogAddress  string `json:"syslog_address,omitempty"`
+	Socket         string `json:"socket,omitempty"`
+	Sinks          int    `json:"sinks"`
+	EntriesWritten uint64 `json:"entries_written"`
+	BytesWritten   uint64 `json:"bytes_written"`
+	WriteErrors    uint64 `json:"write_errors"`
+	Dropped        uint64 `json:"dropped"`
+	// RateLimited dropped 里因为 rate_limit 丢弃的条数
+	RateLimited uint64 `json:"rate_limited,omitempty"`
+	// Healthy 最近一次写日志所有输出都成功
+	Healthy   bool   `json:"healthy"`
+	LastError string `json:"last_error,omitempty"`
+	QueueLen  int    `json:"queue_len,omitempty"`
+	// HTTPSinkBreaker SyslogBreaker SocketBreaker 远端输出熔断器的状态, closed open 或 half_open
+	HTTPSinkBreaker string `json:"http_sink_breaker,omitempty"`
+	SyslogBreaker   string `json:"syslog_breaker,omitempty"`
+	SocketBreaker   string `json:"socket_breaker,omitempty"`
+	QueueCap        int    `json:"queue_cap,omitempty"`
+}
+
+// RuntimeStatus 当前的运行状态
+func (z *ZLog) RuntimeStatus() RuntimeStatus {
+	s := RuntimeStatus{
+		RouteName:      z.RouteName,
+		FileName:       z.FileWriter.Filename,
+		HTTPSink:       z.HTTPSink,
+		SyslogAddress:  z.SyslogAddress,
+		Socket:         z.Socket,
+		EntriesWritten: z.written.Load(),
+		BytesWritten:   z.writtenBytes.Load(),
+		WriteErrors:    z.writeErrors.Load(),
+		Dropped:        z.Dropped(),
+		RateLimited:    z.rateLimited.Load(),
+		Healthy:        !z.unhealthy.Load(),
+	}
+	if err, ok := z.lastError.Load().(string); ok {
+		s.LastError = err
+	}
+	z.outputMu.Lock()
+	s.Sinks = len(z.sinks)
+	for _, sink := range z.sinks {
+		switch sink := sink.(type) {
+		case *httpSink:
+			s.HTTPSinkBreaker = sink.breaker.State()
+		case *syslogSink:
+			s.SyslogBreaker = sink.breaker.State()
+		case *socketSink:
+			s.SocketBreaker = sink.breaker.State()
+		}
+	}
+	z.outputMu.Unlock()
+	z.queueMu.RLock()
+	s.QueueLen, s.QueueCap = len(z.queue), cap(z.queue)
+	z.queueMu.RUnlock()
+	return s
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed"),
+		}
+	}
+	instances.Lock()
+	list := make([]RuntimeStatus, 0, len(instances.list))
+	for _, z := range instances.list {
+		list = append(list, z.RuntimeStatus())
+	}
+	instances.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	return json.NewEncoder(w).Encode(list)
+}
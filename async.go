@@ -0,0 +1,81 @@
+package zlog
+
+import "bytes"
+
+// logLine 格式化好的一行日志, status 用于 syslog 这类需要日志级别的输出
+type logLine struct {
+	buf    *bytes.Buffer
+	status int
+	// slow 超过 slow_threshold, 额外写到 slow_file
+	slow bool
+	// start start_log 的开始日志, 这时还没有状态码, 只写到默认的输出, 不写按状态码分类的文件
+	start bool
+}
+
+// startAsync 启动后台写日志的 goroutine
+func (z *ZLog) startAsync() {
+	size := z.AsyncBuffer
+	if size <= 0 {
+		size = DefaultAsyncBuffer
+	}
+	z.queue = make(chan logLine, size)
+	z.done = make(chan struct{})
+	queue := z.queue
+	go func() {
+		defer close(z.done)
+		for {
+			select {
+			case line, ok := <-queue:
+				if !ok {
+					return
+				}
+				z.output(line)
+				putBuffer(line.buf)
+			case <-z.ctx.Done():
+				// 剩下的日志由 stopAsync 写完
+				return
+			}
+		}
+	}()
+}
+
+// stopAsync 关闭队列并等待剩余日志写完
+func (z *ZLog) stopAsync() {
+	z.queueMu.Lock()
+	queue := z.queue
+	z.queue = nil
+	z.queueMu.Unlock()
+	if queue == nil {
+		return
+	}
+	close(queue)
+	<-z.done
+	for line := range queue {
+		z.output(line)
+		putBuffer(line.buf)
+	}
+}
+
+// write 同步模式直接写, 异步模式放进队列, 队列满时丢弃
+// 写完之后 line.buf 会被还回 bufPool, 调用方不能再使用
+func (z *ZLog) write(line logLine) {
+	// 读锁保证不会往已经关闭的队列里发送
+	z.queueMu.RLock()
+	defer z.queueMu.RUnlock()
+	if z.queue == nil {
+		z.output(line)
+		putBuffer(line.buf)
+		return
+	}
+	select {
+	case z.queue <- line:
+	default:
+		z.countDropped(1)
+		putBuffer(line.buf)
+	}
+}
+
+// Dropped 异步队列或者 http_sink 队列满, 以及超过 rate_limit 时丢弃的日志条数
+func (z *ZLog) Dropped() uint64 {
+	return z.dropped.Load()
+}
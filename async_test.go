@@ -0,0 +1,90 @@
+package zlog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/logging"
+)
+
+// 并发请求经过异步队列写到多个输出, 每一行都是完整的 json, 用 -race 运行
+func TestConcurrentServeHTTP(t *testing.T) {
+	const workers, requests = 16, 50
+	dir := t.TempDir()
+	z := &ZLog{
+		FileName:    filepath.Join(dir, "access.log"),
+		StatusFiles: map[string]*logging.FileWriter{"5xx": {Filename: filepath.Join(dir, "error.log")}},
+		Format:      FormatJSON,
+		Async:       true,
+	}
+	sink := provisionTest(t, z)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < requests; j++ {
+				body := strings.Repeat(fmt.Sprintf("%d-%d,", i, j), 20)
+				r := newTestRequest("POST", fmt.Sprintf("/w%d/r%d", i, j), strings.NewReader(body))
+				r.Header.Set("Content-Type", "text/plain")
+				err := serveTest(z, httptest.NewRecorder(), r, func(w http.ResponseWriter, r *http.Request) error {
+					if j%5 == 0 {
+						w.WriteHeader(http.StatusInternalServerError)
+					}
+					_, err := io.Copy(w, r.Body)
+					return err
+				})
+				if err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	// Cleanup 等队列里剩下的日志写完
+	z.Cleanup()
+
+	if n := len(sink.lines()); n != workers*requests {
+		t.Fatalf("want %d entries, got %d", workers*requests, n)
+	}
+	for _, line := range sink.lines() {
+		m := decodeEntry(t, line)
+		path, _ := m["path"].(string)
+		var i, j int
+		if _, err := fmt.Sscanf(path, "/w%d/r%d", &i, &j); err != nil {
+			t.Fatalf("bad path in %q", line)
+		}
+		// 请求体和响应体都没有混进其他请求的内容
+		want := strings.Repeat(fmt.Sprintf("%d-%d,", i, j), 20)
+		if m["req_body"] != want || m["resp_body"] != want {
+			t.Fatalf("body mixed up in %q", line)
+		}
+	}
+	counts := map[string]int{}
+	for _, name := range []string{"access.log", "error.log"} {
+		f, err := os.Open(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if !json.Valid(scanner.Bytes()) {
+				t.Fatalf("%s: malformed line %q", name, scanner.Text())
+			}
+			counts[name]++
+		}
+		f.Close()
+	}
+	if counts["error.log"] != workers*requests/5 || counts["access.log"] != workers*requests*4/5 {
+		t.Fatalf("unexpected file counts %v", counts)
+	}
+}
@@ -0,0 +1,87 @@
+package zlog
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	DefaultBreakerFailures = 5
+	DefaultBreakerCooldown = 30 * time.Second
+)
+
+// 熔断器的状态
+const (
+	breakerClosed   = "closed"
+	breakerOpen     = "open"
+	breakerHalfOpen = "half_open"
+)
+
+// breaker 远端输出的熔断器
+// 连续失败 failures 次之后熔断 cooldown, 这段时间内直接丢弃日志
+// 冷却结束后只放行一次探测, 成功则恢复, 失败则继续熔断
+type breaker struct {
+	failures int
+	cooldown time.Duration
+
+	mu        sync.Mutex
+	state     string
+	fails     int
+	openUntil time.Time
+}
+
+func (z *ZLog) newBreaker() *breaker {
+	b := &breaker{
+		failures: z.BreakerFailures,
+		cooldown: time.Duration(z.BreakerCooldown),
+		state:    breakerClosed,
+	}
+	if b.failures <= 0 {
+		b.failures = DefaultBreakerFailures
+	}
+	if b.cooldown <= 0 {
+		b.cooldown = DefaultBreakerCooldown
+	}
+	return b
+}
+
+// allow 是否可以尝试写入, 熔断时返回 false
+func (b *breaker) allow() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case breakerOpen:
+		if time.Now().Before(b.openUntil) {
+			return false
+		}
+		b.state = breakerHalfOpen
+		return true
+	case breakerHalfOpen:
+		// 已经有一个探测在进行
+		return false
+	}
+	return true
+}
+
+// done 记录 allow 之后写入的结果
+func (b *breaker) done(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if err == nil {
+		b.state = breakerClosed
+		b.fails = 0
+		return
+	}
+	b.fails++
+	if b.state == breakerHalfOpen || b.fails >= b.failures {
+		b.state = breakerOpen
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+// State closed, open 或 half_open
+func (b *breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
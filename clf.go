@@ -0,0 +1,39 @@
+package zlog
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+)
+
+// clfTimeLayout Common Log Format 的时间格式
+const clfTimeLayout = "02/Jan/2006:15:04:05 -0700"
+
+// clfValue 空值输出 -
+func clfValue(v string) string {
+	if v == "" {
+		return "-"
+	}
+	return v
+}
+
+// writeCLFLog Apache 的 Common Log Format, combined 额外输出 Referer 和 User-Agent
+// host ident authuser [date] "request" status bytes
+func (p *proxyWriter) writeCLFLog(e *Entry, w io.Writer) {
+	size := "-"
+	if e.RespSize > 0 {
+		size = strconv.Itoa(e.RespSize)
+	}
+	fmt.Fprintf(w, "%s - %s [%s] %q %d %s",
+		e.ClientIP,
+		clfValue(e.User),
+		e.Time.UTC().Format(clfTimeLayout),
+		e.Method+" "+e.Path+" "+e.Proto,
+		e.Status,
+		size,
+	)
+	if p.z.Format == FormatCombined {
+		fmt.Fprintf(w, " %q %q", clfValue(e.Referer), clfValue(e.UserAgent))
+	}
+	io.WriteString(w, p.z.lineSeparator())
+}
@@ -0,0 +1,122 @@
+package zlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+// Caddyfile 解析出来的配置经过 json 往返后不变, 和 caddy adapt 之后用 json 配置的效果一致
+func TestJSONRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	input := `zlog {
+		file_name ` + filepath.Join(dir, "access.log") + `
+		file_5xx ` + filepath.Join(dir, "error.log") + `
+		roll_size 10MB
+		truncate 4KB
+		truncate_response 8KB
+		status 400-599
+		format json
+		labels env=prod
+		slow_threshold 2s
+		redact_headers X-Token
+		max_body_log 1MB
+		truncate_header on
+	}`
+	var z ZLog
+	if err := z.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(&z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 文件名只在 file_writer 里出现一次
+	if strings.Contains(string(data), `"file_name"`) {
+		t.Errorf("file name serialized twice: %s", data)
+	}
+	var got ZLog
+	if err := json.Unmarshal(data, &got); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&got, &z) {
+		t.Fatalf("round trip changed config:\n%+v\n%+v", &got, &z)
+	}
+
+	// Provision 补上的默认值同样能往返
+	provisionTest(t, &z)
+	data, err = json.Marshal(&z)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var reloaded ZLog
+	if err := json.Unmarshal(data, &reloaded); err != nil {
+		t.Fatal(err)
+	}
+	again, err := json.Marshal(&reloaded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(data, again) {
+		t.Fatalf("provisioned config changed after round trip:\n%s\n%s", data, again)
+	}
+	provisionTest(t, &reloaded)
+}
+
+// json 配置里的 file_name 简写等同于 file_writer.filename
+func TestJSONFileName(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "access.log")
+	var z ZLog
+	if err := json.Unmarshal([]byte(`{"file_name":`+strconv.Quote(name)+`,"truncate":16}`), &z); err != nil {
+		t.Fatal(err)
+	}
+	provisionTest(t, &z)
+	if z.FileWriter.Filename != name || z.LogFile == nil {
+		t.Fatalf("file_name not opened: %q", z.FileWriter.Filename)
+	}
+}
+
+// json 配置不经过 UnmarshalCaddyfile, 非法的值要在加载时报错
+func TestJSONConfigRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{"format", `{"format":"yaml"}`},
+		{"binary_encoding", `{"binary_encoding":"hex"}`},
+		{"syslog_network", `{"syslog_network":"sctp"}`},
+		{"syslog_facility", `{"syslog_facility":"local9"}`},
+		{"sample negative", `{"sample":-0.5}`},
+		{"sample over one", `{"sample":1.5}`},
+		{"status reversed", `{"status":[{"min":599,"max":500}]}`},
+		{"status out of range", `{"status":[{"min":0,"max":99}]}`},
+		{"body_hash", `{"body_hash":"md4"}`},
+		{"level_map", `{"level_map":{"5xx":"fatal"}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			z := &ZLog{DisableStdout: true}
+			if err := json.Unmarshal([]byte(tt.config), z); err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+			defer cancel()
+			defer z.Cleanup()
+			err := z.Provision(ctx)
+			if err == nil {
+				err = z.Validate()
+			}
+			if err == nil {
+				t.Fatalf("config %s accepted", tt.config)
+			}
+		})
+	}
+}
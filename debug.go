@@ -0,0 +1,93 @@
+package zlog
+
+import (
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// effectiveConfig 所有配置项的实际值, 没有配置的选项填上默认值, key 和 json 配置的名字相同
+func (z *ZLog) effectiveConfig() map[string]interface{} {
+	cfg := make(map[string]interface{})
+	v := reflect.ValueOf(z).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if !field.IsExported() || name == "" || name == "-" {
+			continue
+		}
+		cfg[name] = v.Field(i).Interface()
+	}
+	defaults := map[string]interface{}{
+		"format":              FormatText,
+		"time_format":         TimeFormatRFC3339,
+		"body_content_types":  DefaultBodyContentTypes,
+		"cache_status_header": "Cache-Status",
+		"async_buffer":        DefaultAsyncBuffer,
+		"dedup_window":        caddy.Duration(DefaultDedupWindow),
+		"http_sink_batch":     DefaultHTTPSinkBatch,
+		"http_sink_flush":     caddy.Duration(DefaultHTTPSinkFlush),
+		"http_sink_timeout":   caddy.Duration(DefaultHTTPSinkTimeout),
+		"breaker_failures":    DefaultBreakerFailures,
+		"breaker_cooldown":    caddy.Duration(DefaultBreakerCooldown),
+		"line_separator":      z.lineSeparator(),
+		"truncate_request":    z.truncateSize(z.TruncateRequest),
+		"truncate_response":   z.truncateSize(z.TruncateResponse),
+	}
+	for name, def := range defaults {
+		if f := reflect.ValueOf(cfg[name]); !f.IsValid() || f.IsZero() {
+			cfg[name] = def
+		}
+	}
+	cfg["redact_headers"] = append(append([]string{}, DefaultRedactHeaders...), z.RedactHeaders...)
+	if len(z.ResponseBodyContentTypes) == 0 {
+		cfg["response_body_content_types"] = cfg["body_content_types"]
+	}
+	// caddy.Duration 序列化成纳秒不方便看
+	for name, value := range cfg {
+		if d, ok := value.(caddy.Duration); ok {
+			cfg[name] = time.Duration(d).String()
+		}
+	}
+	return cfg
+}
+
+// logConfig 配置检查通过后用 caddy 的 logger 输出实际生效的配置
+func (z *ZLog) logConfig() {
+	if z.logger == nil {
+		return
+	}
+	z.logger.Info("zlog config", zap.Any("config", z.effectiveConfig()))
+}
+
+// passReason 不需要包装请求的原因, 为空时需要记录
+func (z *ZLog) passReason(r *http.Request) string {
+	switch {
+	case z.stopping():
+		return "module stopping"
+	case !z.hasOutput():
+		return "no output"
+	case !z.matchMethod(r.Method):
+		return "method not matched"
+	case !z.matchPath(r.URL.Path):
+		return "path not matched"
+	}
+	return ""
+}
+
+// decision 开启 debug 时记录每个请求是否写了日志以及原因
+func (z *ZLog) decision(r *http.Request, decision string) {
+	if !z.Debug || z.logger == nil {
+		return
+	}
+	z.logger.Info("zlog decision",
+		zap.String("method", r.Method),
+		zap.String("uri", r.RequestURI),
+		zap.String("decision", decision),
+	)
+}
@@ -0,0 +1,89 @@
+package zlog
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// bodyDecoder 解码某种 Content-Encoding 的响应体
+type bodyDecoder interface {
+	Decode(data []byte) (io.Reader, error)
+}
+
+type decoderFunc func(data []byte) (io.Reader, error)
+
+func (f decoderFunc) Decode(data []byte) (io.Reader, error) {
+	return f(data)
+}
+
+// bodyDecoders 总是启用的解码器
+var bodyDecoders = map[string]bodyDecoder{
+	"gzip":   decoderFunc(decodeGzip),
+	"x-gzip": decoderFunc(decodeGzip),
+	"deflate": decoderFunc(func(data []byte) (io.Reader, error) {
+		// 规范里 deflate 是 zlib 格式, 但有些服务端直接发送 raw deflate
+		if r, err := zlib.NewReader(bytes.NewReader(data)); err == nil {
+			return r, nil
+		}
+		return flate.NewReader(bytes.NewReader(data)), nil
+	}),
+}
+
+// extraBodyDecoders log_decompress on 时启用的解码器
+var extraBodyDecoders = map[string]bodyDecoder{
+	"br": decoderFunc(func(data []byte) (io.Reader, error) {
+		return brotli.NewReader(bytes.NewReader(data)), nil
+	}),
+	"zstd": decoderFunc(func(data []byte) (io.Reader, error) {
+		d, err := zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	}),
+}
+
+func decodeGzip(data []byte) (io.Reader, error) {
+	return gzip.NewReader(bytes.NewReader(data))
+}
+
+func (z *ZLog) bodyDecoder(encoding string) bodyDecoder {
+	encoding = strings.ToLower(strings.TrimSpace(encoding))
+	if d, ok := bodyDecoders[encoding]; ok {
+		return d
+	}
+	if z.LogDecompress {
+		return extraBodyDecoders[encoding]
+	}
+	return nil
+}
+
+// decodedRespBuf 按 Content-Encoding 解压响应体, 只影响日志, 不影响发给客户端的数据
+// 解压后的大小同样受 truncate 限制, 防止 zip 炸弹, 不认识的编码原样返回
+func (p *proxyWriter) decodedRespBuf() *bytes.Buffer {
+	decoder := p.z.bodyDecoder(p.header().Get("Content-Encoding"))
+	if decoder == nil {
+		return p.respBuf
+	}
+	r, err := decoder.Decode(p.respBuf.Bytes())
+	if err != nil {
+		return p.respBuf
+	}
+	if c, ok := r.(io.Closer); ok {
+		defer c.Close()
+	}
+	out := new(bytes.Buffer)
+	// 响应体可能被截断, 解压到截断处报错是正常的, 保留已经解出来的部分
+	_, err = io.Copy(out, io.LimitReader(r, int64(p.respTruncate)))
+	if err != nil && out.Len() == 0 {
+		return p.respBuf
+	}
+	return out
+}
@@ -0,0 +1,84 @@
+package zlog
+
+import (
+	"bytes"
+	"fmt"
+	"time"
+)
+
+// DefaultDedupWindow 合并重复日志的默认时间窗口
+const DefaultDedupWindow = time.Second
+
+// dedupState 等待合并的一行日志
+type dedupState struct {
+	key   string
+	line  logLine
+	count int
+	timer *time.Timer
+}
+
+// dedupKey 方法, 路径和状态码都相同就认为是重复的日志
+func (p *proxyWriter) dedupKey() string {
+	return fmt.Sprintf("%s %s %d", p.req.Method, p.path(), p.code)
+}
+
+// writeDedup 和上一行重复时只计数, 否则先写出上一行
+// 一行日志最多等待 DedupWindow, 之后的重复会作为新的一行
+func (z *ZLog) writeDedup(key string, line logLine) {
+	z.dedupMu.Lock()
+	defer z.dedupMu.Unlock()
+	if z.pending != nil && z.pending.key == key {
+		z.pending.count++
+		putBuffer(line.buf)
+		return
+	}
+	z.flushPendingLocked()
+	window := time.Duration(z.DedupWindow)
+	if window <= 0 {
+		window = DefaultDedupWindow
+	}
+	pending := &dedupState{key: key, line: line, count: 1}
+	pending.timer = time.AfterFunc(window, func() {
+		z.dedupMu.Lock()
+		defer z.dedupMu.Unlock()
+		// 计时器触发前可能已经被新的一行替换
+		if z.pending == pending {
+			z.flushPendingLocked()
+		}
+	})
+	z.pending = pending
+}
+
+// flushPending 写出等待合并的日志, Cleanup 时调用
+func (z *ZLog) flushPending() {
+	z.dedupMu.Lock()
+	defer z.dedupMu.Unlock()
+	z.flushPendingLocked()
+}
+
+func (z *ZLog) flushPendingLocked() {
+	pending := z.pending
+	if pending == nil {
+		return
+	}
+	z.pending = nil
+	pending.timer.Stop()
+	if pending.count > 1 {
+		z.annotateRepeated(pending.line.buf, pending.count)
+	}
+	z.write(pending.line)
+}
+
+// annotateRepeated 在行尾加上重复次数, json 格式加一个 repeated 字段
+func (z *ZLog) annotateRepeated(buf *bytes.Buffer, count int) {
+	sep := z.lineSeparator()
+	n := len(bytes.TrimSuffix(buf.Bytes(), []byte(sep)))
+	if z.template == nil && z.Format == FormatJSON && n > 0 && buf.Bytes()[n-1] == '}' {
+		buf.Truncate(n - 1)
+		fmt.Fprintf(buf, `,"repeated":%d}`, count)
+	} else {
+		buf.Truncate(n)
+		fmt.Fprintf(buf, " (repeated %d times)", count)
+	}
+	buf.WriteString(sep)
+}
@@ -0,0 +1,87 @@
+package zlog
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// encryptedPrefix 加密后的每行日志都以这个前缀开头
+const encryptedPrefix = "enc:"
+
+// parseEncryptKey key 是 base64 编码的 16, 24 或 32 字节, 分别对应 AES-128/192/256
+func parseEncryptKey(key string) (cipher.AEAD, error) {
+	raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil {
+		return nil, fmt.Errorf("key must be base64 encoded: %v", err)
+	}
+	block, err := aes.NewCipher(raw)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// loadEncryptKey 从 EncryptKeyFile 或者 EncryptKeyEnv 读取 key, 都没有配置时返回 nil
+func (z *ZLog) loadEncryptKey() (cipher.AEAD, error) {
+	var key string
+	switch {
+	case z.EncryptKeyFile != "":
+		data, err := os.ReadFile(z.EncryptKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("read encrypt key: %v", err)
+		}
+		key = string(data)
+	case z.EncryptKeyEnv != "":
+		key = os.Getenv(z.EncryptKeyEnv)
+		if key == "" {
+			return nil, fmt.Errorf("encrypt key env %s is empty", z.EncryptKeyEnv)
+		}
+	default:
+		return nil, nil
+	}
+	aead, err := parseEncryptKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid encrypt key: %v", err)
+	}
+	return aead, nil
+}
+
+// encryptLine 整行加密, 输出 enc: + base64(nonce + 密文) + 行分隔符
+func (z *ZLog) encryptLine(line []byte) []byte {
+	sep := z.lineSeparator()
+	plain := bytes.TrimSuffix(line, []byte(sep))
+	nonce := make([]byte, z.aead.NonceSize(), z.aead.NonceSize()+len(plain)+z.aead.Overhead())
+	rand.Read(nonce)
+	sealed := z.aead.Seal(nonce, nonce, plain, nil)
+	return []byte(encryptedPrefix + base64.StdEncoding.EncodeToString(sealed) + sep)
+}
+
+// DecryptLine 解密 encrypt 写出的一行日志, key 和配置里的一样是 base64 编码
+func DecryptLine(key, line string) (string, error) {
+	aead, err := parseEncryptKey(key)
+	if err != nil {
+		return "", err
+	}
+	data, ok := strings.CutPrefix(strings.TrimSpace(line), encryptedPrefix)
+	if !ok {
+		return "", fmt.Errorf("not an encrypted line")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return "", fmt.Errorf("encrypted line too short")
+	}
+	plain, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
@@ -0,0 +1,174 @@
+package zlog
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Entry 一条访问日志的数据, 文本, json, clf 和模板格式都从这里取值
+// EntryHook 修改的字段会反映到输出里, Status 同时决定写到哪个 status_file 和去重的 key
+type Entry struct {
+	// Time 请求结束的时间
+	Time     time.Time
+	Duration time.Duration
+	// ReadTime UpstreamTime WriteTime 读请求体, 等待下游首字节, 写响应的分段耗时
+	ReadTime     time.Duration
+	UpstreamTime time.Duration
+	WriteTime    time.Duration
+
+	Status int
+	// Level 按 level_map 从状态码算出的级别
+	Level    string
+	Method   string
+	Scheme   string
+	Host     string
+	Path     string
+	Proto    string
+	ClientIP string
+	TraceID  string
+	// Route route_name 配置的路由名字
+	Route string
+	// SpanID 开启 otel 时 traceparent 里的 span id
+	SpanID string
+	// RequestUUID 开启 log_request_uuid 时 caddy 的 {http.request.uuid}
+	RequestUUID string
+	// GraphQLOperation GraphQLQuery 开启 graphql_aware 时从请求体里取出的 operationName 和 query
+	GraphQLOperation string
+	GraphQLQuery     string
+
+	User        string
+	UserAgent   string
+	Referer     string
+	Upstream    string
+	CacheStatus string
+	TLS         *tls.ConnectionState
+
+	ReqContentType  string
+	RespContentType string
+	ReqSize         int
+	RespSize        int
+	// ReqSizeDeclared 下游没有读请求体, ReqSize 取自 Content-Length
+	ReqSizeDeclared bool
+	// ReqBodyPartial 下游只读了一部分请求体就返回了, ReqContentLength 是声明的大小, 分块传输时为 -1
+	ReqBodyPartial   bool
+	ReqContentLength int64
+	// ReqBody RespBody 捕获到的原始字节, 可能被截断, 响应体没有解压
+	// 底层的 buffer 会被复用, hook 返回后不能再持有, hook 换成新的 slice 时会拷贝回 buffer
+	ReqBody  []byte
+	RespBody []byte
+	// Trailers 开启 log_trailers 时响应的 trailer, 输出时按 redact_headers 脱敏
+	Trailers http.Header
+	// Upgrade 连接被接管或者 101 切换协议时切换到的协议
+	Upgrade            string
+	ClientDisconnected bool
+	// Slow 耗时超过 slow_threshold
+	Slow bool
+
+	// Request 原始的请求, 只能读取, body 已经被下游读过
+	Request *http.Request
+	// Labels labels 配置的固定字段, 所有日志共用, 不要修改
+	Labels map[string]string
+	// Extra hook 添加的字段, 文本格式追加到行尾, json 格式放在 extra 里
+	Extra map[string]string
+}
+
+// entry 请求结束后收集日志数据
+func (p *proxyWriter) entry(end time.Time) *Entry {
+	d := end.Sub(p.start)
+	read, upstream, write := p.timings(d)
+	e := &Entry{
+		Time:               end,
+		Duration:           d,
+		ReadTime:           read,
+		UpstreamTime:       upstream,
+		WriteTime:          write,
+		Status:             p.code,
+		Level:              p.z.level(p.code),
+		Method:             p.req.Method,
+		Scheme:             p.scheme(),
+		Host:               p.req.Host,
+		Path:               p.path(),
+		Proto:              p.req.Proto,
+		ClientIP:           p.clientIP(),
+		Route:              p.z.RouteName,
+		TraceID:            p.traceID,
+		SpanID:             p.spanID,
+		User:               p.user(),
+		UserAgent:          p.userAgent(),
+		Referer:            p.req.Referer(),
+		Upstream:           p.upstream(),
+		CacheStatus:        p.cacheStatus(),
+		TLS:                p.req.TLS,
+		ReqContentType:     p.req.Header.Get("Content-Type"),
+		RespContentType:    p.header().Get("Content-Type"),
+		ReqSize:            p.reqSize,
+		RespSize:           p.respSize,
+		ReqBody:            p.reqBuf.Bytes(),
+		RespBody:           p.respBuf.Bytes(),
+		ClientDisconnected: p.clientDisconnected,
+		Slow:               p.z.isSlow(d),
+		Trailers:           p.trailers(),
+		Request:            p.req,
+		Labels:             p.z.labels,
+	}
+	// 下游没有读 body 时 reqSize 是 0, 用 Content-Length 避免误记成空请求体
+	if p.reqSize == 0 && p.req.ContentLength > 0 {
+		e.ReqSize = int(p.req.ContentLength)
+		e.ReqSizeDeclared = true
+	}
+	e.GraphQLOperation, e.GraphQLQuery = p.graphql()
+	e.ReqBodyPartial = p.reqPartial()
+	e.ReqContentLength = p.req.ContentLength
+	if p.z.LogRequestUUID {
+		e.RequestUUID = p.requestUUID()
+	}
+	if p.upgraded() {
+		// 响应的 Upgrade 是实际切换到的协议, 没有时用请求里的
+		if e.Upgrade = p.header().Get("Upgrade"); e.Upgrade == "" {
+			e.Upgrade = p.req.Header.Get("Upgrade")
+		}
+	}
+	return e
+}
+
+// applyEntry hook 之后格式化和分发都从 writer 取值, 把 hook 修改的状态码和 body 同步回来
+// 只改了 Status 没改 Level 时, Level 按新的状态码重新计算
+func (p *proxyWriter) applyEntry(e *Entry) {
+	if e.Status != p.code {
+		if e.Level == p.z.level(p.code) {
+			e.Level = p.z.level(e.Status)
+		}
+		p.code = e.Status
+	}
+	setBuffer(p.reqBuf, e.ReqBody)
+	setBuffer(p.respBuf, e.RespBody)
+}
+
+// setBuffer 把 buf 的内容换成 b, b 就是 buf 当前的内容时不拷贝
+func setBuffer(buf *bytes.Buffer, b []byte) {
+	cur := buf.Bytes()
+	if len(b) == len(cur) && (len(b) == 0 || &b[0] == &cur[0]) {
+		return
+	}
+	buf.Reset()
+	buf.Write(b)
+}
+
+// reqPartial 下游读了请求体但是没有读到 EOF, 而且没有读满 Content-Length
+func (p *proxyWriter) reqPartial() bool {
+	if p.reqSize == 0 || p.reqDone.Load() != 0 {
+		return false
+	}
+	return p.req.ContentLength < 0 || int64(p.reqSize) < p.req.ContentLength
+}
+
+// partialNote 请求体只读了一部分时的说明, 例如 read 1024 of 4096 bytes
+func (e *Entry) partialNote() string {
+	if e.ReqContentLength < 0 {
+		return fmt.Sprintf("read %d bytes, not to the end", e.ReqSize)
+	}
+	return fmt.Sprintf("read %d of %d bytes", e.ReqSize, e.ReqContentLength)
+}
@@ -0,0 +1,79 @@
+package zlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// jsonFieldZeros jsonLine 里所有的 key 和对应类型的零值
+// 被 omitempty 省略的字段按零值输出, 同一个字段在每条日志里的类型一致
+var jsonFieldZeros = func() map[string]json.RawMessage {
+	zeros := make(map[string]json.RawMessage)
+	t := reflect.TypeOf(jsonLine{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		zeros[name] = marshalJSON(reflect.Zero(t.Field(i).Type).Interface())
+	}
+	return zeros
+}()
+
+// provisionFields 检查 fields 里的字段名
+// 文本格式按顺序输出 format_template 的占位符, 用空格分隔
+// json 格式还可以选择 jsonLine 里的字段, 例如 req_headers
+func (z *ZLog) provisionFields() error {
+	if len(z.Fields) == 0 {
+		return nil
+	}
+	if z.FormatTemplate != "" {
+		return fmt.Errorf("fields can not be used with format_template")
+	}
+	if z.Format == FormatCLF || z.Format == FormatCombined {
+		return fmt.Errorf("fields can not be used with format %s", z.Format)
+	}
+	for _, name := range z.Fields {
+		_, ok := templateFields[name]
+		if _, isJSON := jsonFieldZeros[name]; !ok && (z.Format != FormatJSON || !isJSON) {
+			return fmt.Errorf("unknown field: %s", name)
+		}
+	}
+	if z.Format == FormatJSON {
+		return nil
+	}
+	for i, name := range z.Fields {
+		if i > 0 {
+			z.template = append(z.template, templateSegment{text: " "})
+		}
+		z.template = append(z.template, templateSegment{field: name})
+	}
+	return nil
+}
+
+// selectFields 按 fields 的顺序从完整的 json 日志里取出字段
+// 因为 omitempty 被省略的字段输出对应类型的零值, jsonLine 里没有的字段使用占位符的值
+func (p *proxyWriter) selectFields(data []byte, e *Entry) []byte {
+	var all map[string]json.RawMessage
+	json.Unmarshal(data, &all)
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, name := range p.z.Fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.Write(marshalJSON(name))
+		buf.WriteByte(':')
+		if v, ok := all[name]; ok {
+			buf.Write(v)
+		} else if zero, ok := jsonFieldZeros[name]; ok {
+			buf.Write(zero)
+		} else if fn, ok := templateFields[name]; ok {
+			buf.Write(marshalJSON(fn(p, e)))
+		} else {
+			buf.WriteString("null")
+		}
+	}
+	buf.WriteByte('}')
+	return buf.Bytes()
+}
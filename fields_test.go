@@ -0,0 +1,42 @@
+package zlog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// 被 omitempty 省略的字段按类型输出零值, 设置和没设置时的 json 类型一致
+func TestSelectFieldsTypes(t *testing.T) {
+	fields := []string{"status", "slow", "client_disconnected", "req_body_partial", "req_size_declared", "upgrade", "req_headers"}
+	for _, threshold := range []int64{0, 1} {
+		z := &ZLog{Format: FormatJSON, Fields: fields, SlowThreshold: caddy.Duration(threshold)}
+		sink := provisionTest(t, z)
+		err := serveTest(z, httptest.NewRecorder(), newTestRequest("GET", "/", nil), func(w http.ResponseWriter, r *http.Request) error {
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := decodeEntry(t, sink.lines()[0])
+		if len(m) != len(fields) {
+			t.Errorf("want %d fields, got %v", len(fields), m)
+		}
+		if _, ok := m["status"].(float64); !ok {
+			t.Errorf("status is %T", m["status"])
+		}
+		for _, name := range []string{"slow", "client_disconnected", "req_body_partial", "req_size_declared"} {
+			if _, ok := m[name].(bool); !ok {
+				t.Errorf("slow_threshold %d: %s is %T %v, want bool", threshold, name, m[name], m[name])
+			}
+		}
+		if m["slow"] != (threshold > 0) {
+			t.Errorf("slow = %v", m["slow"])
+		}
+		if _, ok := m["upgrade"].(string); !ok {
+			t.Errorf("upgrade is %T", m["upgrade"])
+		}
+	}
+}
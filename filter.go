@@ -0,0 +1,133 @@
+package zlog
+
+import (
+	"fmt"
+	"math/rand"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// StatusRange 闭区间 [Min, Max] 的状态码
+type StatusRange struct {
+	Min int `json:"min"`
+	Max int `json:"max"`
+}
+
+// parseStatusRange 解析 404 或者 400-599 这样的状态码
+func parseStatusRange(s string) (sr StatusRange, err error) {
+	lo, hi, isRange := strings.Cut(s, "-")
+	if sr.Min, err = strconv.Atoi(lo); err != nil {
+		return
+	}
+	sr.Max = sr.Min
+	if isRange {
+		if sr.Max, err = strconv.Atoi(hi); err != nil {
+			return
+		}
+	}
+	if !sr.valid() {
+		err = fmt.Errorf("invalid status range")
+	}
+	return
+}
+
+// valid 状态码在 100-999 之间并且 Min 不大于 Max
+func (sr StatusRange) valid() bool {
+	return sr.Min >= 100 && sr.Max <= 999 && sr.Min <= sr.Max
+}
+
+// matchStatus 多个 status 取并集, 没有调用 WriteHeader 视为 200
+func (z *ZLog) matchStatus(code int) bool {
+	if len(z.Status) == 0 {
+		return true
+	}
+	if code == 0 {
+		code = http.StatusOK
+	}
+	for _, sr := range z.Status {
+		if code >= sr.Min && code <= sr.Max {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPattern 末尾的 * 表示前缀匹配, 其余按 path.Match 的 glob 规则
+func matchPattern(pattern, p string) bool {
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok && !strings.ContainsAny(prefix, "*?[\\") {
+		return strings.HasPrefix(p, prefix)
+	}
+	ok, _ := path.Match(pattern, p)
+	return ok
+}
+
+func matchAny(patterns []string, p string) bool {
+	for _, pattern := range patterns {
+		if matchPattern(pattern, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPath skip_path 优先于 match_path
+func (z *ZLog) matchPath(p string) bool {
+	if matchAny(z.SkipPaths, p) {
+		return false
+	}
+	return len(z.MatchPaths) == 0 || matchAny(z.MatchPaths, p)
+}
+
+// sampled 是否采样当前请求
+func (z *ZLog) sampled() bool {
+	return z.Sample <= 0 || z.Sample >= 1 || rand.Float64() < z.Sample
+}
+
+// isSuccess 2xx, 没有调用 WriteHeader 视为 200
+func isSuccess(code int) bool {
+	return code == 0 || (code >= 200 && code < 300)
+}
+
+// captureContentType 是否缓存该 Content-Type 的 body, 没有 Content-Type 时也缓存
+func (z *ZLog) captureContentType(contentType string) bool {
+	allow := z.BodyContentTypes
+	if len(allow) == 0 {
+		allow = DefaultBodyContentTypes
+	}
+	return matchContentType(allow, contentType)
+}
+
+// captureRespContentType 响应体优先使用 ResponseBodyContentTypes
+func (z *ZLog) captureRespContentType(contentType string) bool {
+	if len(z.ResponseBodyContentTypes) == 0 {
+		return z.captureContentType(contentType)
+	}
+	return matchContentType(z.ResponseBodyContentTypes, contentType)
+}
+
+func matchContentType(allow []string, contentType string) bool {
+	if contentType == "" {
+		return true
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return matchAny(allow, mediaType)
+}
+
+// matchMethod 不区分大小写
+func (z *ZLog) matchMethod(method string) bool {
+	if len(z.Methods) == 0 {
+		return true
+	}
+	for _, m := range z.Methods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
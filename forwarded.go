@@ -0,0 +1,71 @@
+package zlog
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// provisionTrustedProxies 解析 trusted_proxies, 支持单个 ip 和 CIDR
+func (z *ZLog) provisionTrustedProxies() error {
+	for _, s := range z.TrustedProxies {
+		if !strings.Contains(s, "/") {
+			addr, err := netip.ParseAddr(s)
+			if err != nil {
+				return fmt.Errorf("parsing trusted_proxies %s: %v", s, err)
+			}
+			z.trustedProxies = append(z.trustedProxies, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(s)
+		if err != nil {
+			return fmt.Errorf("parsing trusted_proxies %s: %v", s, err)
+		}
+		z.trustedProxies = append(z.trustedProxies, prefix.Masked())
+	}
+	return nil
+}
+
+// fromTrustedProxy 直接连过来的对端是否可信
+// 配置了 trusted_proxies 时按它判断, 否则使用 caddy server 的 trusted_proxies 的结果
+func (p *proxyWriter) fromTrustedProxy() bool {
+	if len(p.z.trustedProxies) == 0 {
+		trusted, _ := caddyhttp.GetVar(p.req.Context(), caddyhttp.TrustedProxyVarKey).(bool)
+		return trusted
+	}
+	host, _, err := net.SplitHostPort(p.req.RemoteAddr)
+	if err != nil {
+		host = p.req.RemoteAddr
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range p.z.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwardedProto 可信的代理转发过来的 X-Forwarded-Proto, 只接受 http 和 https
+func (p *proxyWriter) forwardedProto() string {
+	if !p.z.TrustForwarded || !p.fromTrustedProxy() {
+		return ""
+	}
+	v := p.req.Header.Get("X-Forwarded-Proto")
+	// 经过多层代理时取最前面的, 也就是客户端实际使用的
+	if i := strings.IndexByte(v, ','); i >= 0 {
+		v = v[:i]
+	}
+	switch v = strings.ToLower(strings.TrimSpace(v)); v {
+	case "http", "https":
+		return v
+	}
+	return ""
+}
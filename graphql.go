@@ -0,0 +1,56 @@
+package zlog
+
+import (
+	"encoding/json"
+	"mime"
+	"strings"
+)
+
+// MaxGraphQLQuery graphql_aware 记录的 query 最大长度, 超出的部分用 ... 代替
+const MaxGraphQLQuery = 512
+
+// graphqlRequest graphql over http 的请求体
+type graphqlRequest struct {
+	OperationName string `json:"operationName"`
+	Query         string `json:"query"`
+}
+
+// graphql 从 json 请求体里取出 operationName 和 query, 批量请求的 operationName 用逗号连接, query 取第一个
+// 请求体没有缓存, 被截断或者不是 graphql 时返回空
+func (p *proxyWriter) graphql() (operation, query string) {
+	if !p.z.GraphQLAware || p.reqBuf.Len() == 0 {
+		return
+	}
+	mediaType, _, _ := mime.ParseMediaType(p.req.Header.Get("Content-Type"))
+	if mediaType != "application/json" && mediaType != "application/graphql+json" {
+		return
+	}
+	var batch []graphqlRequest
+	data := p.reqBuf.Bytes()
+	var single graphqlRequest
+	if err := json.Unmarshal(data, &single); err == nil {
+		batch = append(batch, single)
+	} else if err := json.Unmarshal(data, &batch); err != nil {
+		return
+	}
+	var names []string
+	for _, req := range batch {
+		if req.OperationName != "" {
+			names = append(names, req.OperationName)
+		}
+	}
+	operation = strings.Join(names, ",")
+	if len(batch) > 0 {
+		query = compactQuery(batch[0].Query)
+	}
+	return operation, p.z.maskPatterns(query)
+}
+
+// compactQuery 把 query 的换行和缩进压成单个空格, 再按 MaxGraphQLQuery 截断
+func compactQuery(query string) string {
+	query = strings.Join(strings.Fields(query), " ")
+	if len(query) > MaxGraphQLQuery {
+		query = query[:runeStart([]byte(query), MaxGraphQLQuery)] + "..."
+	}
+	return query
+}
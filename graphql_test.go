@@ -0,0 +1,44 @@
+package zlog
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// request_body_on_error 只丢掉成功请求的请求体, graphql 字段照常记录
+func TestGraphQLWithRequestBodyOnError(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   bool
+	}{
+		{"success", http.StatusOK, false},
+		{"error", http.StatusInternalServerError, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			z := &ZLog{Format: FormatJSON, GraphQLAware: true, RequestBodyOnError: true}
+			sink := provisionTest(t, z)
+			r := newTestRequest("POST", "/graphql", strings.NewReader(`{"operationName":"GetUser","query":"query GetUser { user { id } }"}`))
+			r.Header.Set("Content-Type", "application/json")
+			err := serveTest(z, httptest.NewRecorder(), r, func(w http.ResponseWriter, r *http.Request) error {
+				io.Copy(io.Discard, r.Body)
+				w.WriteHeader(tt.status)
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			m := decodeEntry(t, sink.lines()[0])
+			if m["graphql_operation"] != "GetUser" || m["graphql_query"] != "query GetUser { user { id } }" {
+				t.Errorf("graphql_operation = %v graphql_query = %v", m["graphql_operation"], m["graphql_query"])
+			}
+			if hasBody := m["req_body"] != nil && m["req_body"] != ""; hasBody != tt.body {
+				t.Errorf("req_body = %v", m["req_body"])
+			}
+		})
+	}
+}
@@ -0,0 +1,102 @@
+package zlog
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"mime"
+	"strings"
+)
+
+// maxGRPCFrames 最多记录这么多帧的信息, 之后的帧只计数
+const maxGRPCFrames = 32
+
+// grpcFrameHeader 1 字节压缩标记 + 4 字节大端长度
+const grpcFrameHeader = 5
+
+// isGRPC application/grpc 以及 application/grpc+proto 之类的子类型
+func isGRPC(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	return mediaType == "application/grpc" || strings.HasPrefix(mediaType, "application/grpc+")
+}
+
+type grpcFrame struct {
+	compressed bool
+	length     uint32
+}
+
+// grpcFrames 解析 gRPC 的帧头, 帧头和 payload 都可能跨越多次 Write
+// 只记录帧的元数据, 不解码 protobuf, 也不需要缓存 body
+type grpcFrames struct {
+	header [grpcFrameHeader]byte
+	// n 当前帧头已经读到的字节数
+	n int
+	// remain 当前帧还没读到的 payload 字节数
+	remain uint32
+	frames []grpcFrame
+	count  int
+}
+
+func (g *grpcFrames) Write(data []byte) (int, error) {
+	size := len(data)
+	for len(data) > 0 {
+		if g.remain > 0 {
+			k := len(data)
+			if uint32(k) > g.remain {
+				k = int(g.remain)
+			}
+			data = data[k:]
+			g.remain -= uint32(k)
+			continue
+		}
+		k := copy(g.header[g.n:], data)
+		g.n += k
+		data = data[k:]
+		if g.n < grpcFrameHeader {
+			break
+		}
+		g.n = 0
+		frame := grpcFrame{
+			compressed: g.header[0]&1 == 1,
+			length:     binary.BigEndian.Uint32(g.header[1:]),
+		}
+		g.count++
+		if len(g.frames) < maxGRPCFrames {
+			g.frames = append(g.frames, frame)
+		}
+		g.remain = frame.length
+	}
+	return size, nil
+}
+
+// String 例如 grpc frames=2 [len=12] [compressed len=300]
+func (g *grpcFrames) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "grpc frames=%d", g.count)
+	for _, f := range g.frames {
+		if f.compressed {
+			fmt.Fprintf(&b, " [compressed len=%d]", f.length)
+		} else {
+			fmt.Fprintf(&b, " [len=%d]", f.length)
+		}
+	}
+	if g.count > len(g.frames) {
+		b.WriteString(" ...")
+	}
+	// 连接中断或者 body 没有读完
+	if g.n > 0 || g.remain > 0 {
+		b.WriteString(" incomplete")
+	}
+	return b.String()
+}
+
+// respBody 响应体, gRPC 响应只输出帧信息, 其余交给 render
+func (p *proxyWriter) respBody(render func(*bytes.Buffer) interface{}) interface{} {
+	if p.respFrames != nil {
+		return p.respFrames.String()
+	}
+	return markTruncated(render(p.decodedRespBuf()), truncatedMarker(p.respSize, p.respBuf))
+}
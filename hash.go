@@ -0,0 +1,32 @@
+package zlog
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+)
+
+// bodyHashes body_hash 支持的算法
+var bodyHashes = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+}
+
+// newBodyHash 没有配置 body_hash 时返回 nil
+func (z *ZLog) newBodyHash() hash.Hash {
+	if fn, ok := bodyHashes[z.BodyHash]; ok {
+		return fn()
+	}
+	return nil
+}
+
+// hexSum 完整 body 的摘要, 和是否截断无关
+func hexSum(h hash.Hash) string {
+	if h == nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
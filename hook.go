@@ -0,0 +1,35 @@
+package zlog
+
+import "sync"
+
+// EntryHook 写日志之前调用, 可以修改 Entry 的字段和 Extra, 返回 false 时丢弃这条日志
+type EntryHook func(*Entry) bool
+
+// entryHooks RegisterEntryHook 注册的全局 hook
+var entryHooks struct {
+	sync.RWMutex
+	list []EntryHook
+}
+
+// RegisterEntryHook 注册对所有 zlog 实例生效的 hook, 一般在 init 里调用
+// 只针对某个实例的 hook 放到 ZLog.Hooks
+func RegisterEntryHook(h EntryHook) {
+	entryHooks.Lock()
+	defer entryHooks.Unlock()
+	entryHooks.list = append(entryHooks.list, h)
+}
+
+// runHooks 依次调用全局和实例的 hook, 有一个返回 false 就停止
+func (z *ZLog) runHooks(e *Entry) bool {
+	entryHooks.RLock()
+	hooks := entryHooks.list
+	entryHooks.RUnlock()
+	for _, list := range [][]EntryHook{hooks, z.Hooks} {
+		for _, h := range list {
+			if !h(e) {
+				return false
+			}
+		}
+	}
+	return true
+}
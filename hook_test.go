@@ -0,0 +1,50 @@
+package zlog
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHookChangesOutput(t *testing.T) {
+	z := &ZLog{Format: FormatJSON}
+	z.Hooks = []EntryHook{func(e *Entry) bool {
+		e.ReqBody = []byte(strings.ReplaceAll(string(e.ReqBody), "secret", "******"))
+		e.RespBody = e.RespBody[:2]
+		e.Status = http.StatusTeapot
+		return true
+	}}
+	sink := provisionTest(t, z)
+	r := newTestRequest("POST", "/", strings.NewReader(`{"token":"secret"}`))
+	err := serveTest(z, httptest.NewRecorder(), r, func(w http.ResponseWriter, r *http.Request) error {
+		io.Copy(io.Discard, r.Body)
+		_, err := io.WriteString(w, "pong")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := sink.lines()
+	if len(lines) != 1 {
+		t.Fatalf("want one entry, got %q", lines)
+	}
+	m := decodeEntry(t, lines[0])
+	if got := fmt.Sprint(m["req_body"]); !strings.Contains(got, "******") || strings.Contains(got, "secret") {
+		t.Errorf("req_body = %v", got)
+	}
+	if got, _ := m["resp_body"].(string); !strings.HasPrefix(got, "po") || strings.Contains(got, "pong") {
+		t.Errorf("resp_body = %v", got)
+	}
+	if got := m["status"]; got != float64(http.StatusTeapot) {
+		t.Errorf("status = %v", got)
+	}
+	if got := m["level"]; got != LevelWarn {
+		t.Errorf("level = %v", got)
+	}
+	if sink.statuses[0] != http.StatusTeapot {
+		t.Errorf("routed with status %d", sink.statuses[0])
+	}
+}
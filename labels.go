@@ -0,0 +1,40 @@
+package zlog
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// provisionLabels 解析 labels 里的占位符, 例如 {env.HOSTNAME}, 只在加载配置时解析一次
+func (z *ZLog) provisionLabels() {
+	if len(z.Labels) == 0 {
+		return
+	}
+	repl := caddy.NewReplacer()
+	z.labels = make(map[string]string, len(z.Labels))
+	for k, v := range z.Labels {
+		z.labels[k] = repl.ReplaceAll(v, "")
+	}
+}
+
+// parseLabel 解析 key=value
+func parseLabel(arg string) (key, value string, ok bool) {
+	key, value, ok = strings.Cut(arg, "=")
+	return key, value, ok && key != ""
+}
+
+// writeKeyValues 按 key 排序输出 labels 和 hook 添加的字段
+func writeKeyValues(w io.Writer, fields map[string]string) {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, " %s=%q", k, fields[k])
+	}
+}
@@ -0,0 +1,60 @@
+package zlog
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+)
+
+// 日志级别, 默认 5xx 为 error, 4xx 为 warn, 其余为 info
+const (
+	LevelDebug = "debug"
+	LevelInfo  = "info"
+	LevelWarn  = "warn"
+	LevelError = "error"
+)
+
+// syslogLevels 日志级别对应的 syslog 严重级别
+var syslogLevels = map[string]int{
+	LevelDebug: syslogSeverityDebug,
+	LevelInfo:  syslogSeverityInfo,
+	LevelWarn:  syslogSeverityWarning,
+	LevelError: syslogSeverityError,
+}
+
+// levelKey level_map 的 key, 具体的状态码或者 4xx 这样的分类
+var levelKey = regexp.MustCompile(`^[1-5]([0-9]{2}|xx)$`)
+
+// level 状态码对应的日志级别, level_map 里具体的状态码优先于分类
+func (z *ZLog) level(status int) string {
+	if l, ok := z.LevelMap[strconv.Itoa(status)]; ok {
+		return l
+	}
+	if l, ok := z.LevelMap[fmt.Sprintf("%dxx", status/100)]; ok {
+		return l
+	}
+	switch {
+	case status >= 500:
+		return LevelError
+	case status >= 400:
+		return LevelWarn
+	}
+	return LevelInfo
+}
+
+// syslogSeverity syslog 的严重级别和 level 保持一致
+func (z *ZLog) syslogSeverity(status int) int {
+	return syslogLevels[z.level(status)]
+}
+
+func validateLevelMap(m map[string]string) error {
+	for key, level := range m {
+		if !levelKey.MatchString(key) {
+			return fmt.Errorf("invalid level_map status: %s", key)
+		}
+		if _, ok := syslogLevels[level]; !ok {
+			return fmt.Errorf("invalid level_map level %s for %s, must be one of debug info warn error", level, key)
+		}
+	}
+	return nil
+}
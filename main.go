@@ -1,16 +1,30 @@
 package zlog
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"crypto/cipher"
+	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"math"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
+	"path/filepath"
+	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 
 	caddy "github.com/caddyserver/caddy/v2"
 	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
@@ -18,10 +32,69 @@ import (
 	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
 	"github.com/caddyserver/caddy/v2/modules/logging"
 	"github.com/dustin/go-humanize"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
 )
 
 const (
-	DefaultTruncate = 1024
+	DefaultTruncate    = 1024
+	DefaultAsyncBuffer = 10000
+	// MaxUserAgent User-Agent 超过这个长度会被截断
+	MaxUserAgent = 256
+	// MaxTruncateOverride 单个请求覆盖截断大小的上限
+	MaxTruncateOverride = 16 * humanize.MiByte
+
+	DefaultHTTPSinkBatch   = 100
+	DefaultHTTPSinkFlush   = time.Second
+	DefaultHTTPSinkTimeout = 5 * time.Second
+)
+
+// DefaultBodyContentTypes 默认只缓存文本类型的 body
+var DefaultBodyContentTypes = []string{
+	"text/*",
+	"application/json",
+	"application/*+json",
+	"application/xml",
+	"application/*+xml",
+	"application/javascript",
+	"application/x-www-form-urlencoded",
+	"multipart/form-data",
+}
+
+// DefaultRedactHeaders 开启 header 记录时默认脱敏的 header
+var DefaultRedactHeaders = []string{"Authorization", "Cookie", "Set-Cookie", "Proxy-Authorization"}
+
+// 特殊的时间格式, 其余值作为 time.Format 的 layout
+const (
+	TimeFormatRFC3339   = "rfc3339"
+	TimeFormatUnix      = "unix"
+	TimeFormatUnixMilli = "unixmilli"
+)
+
+// BinaryEncodingBase64 非文本 body 用 base64 记录, 文本格式下加 base64: 前缀
+const BinaryEncodingBase64 = "base64"
+
+// 单个请求覆盖截断大小, 例如 vars zlog_truncate 64KB, 同时覆盖请求和响应的截断大小
+// 开启 truncate_header 后请求头 X-Zlog-Truncate 也生效, 但只能调小截断大小
+const (
+	TruncateVar    = "zlog_truncate"
+	TruncateHeader = "X-Zlog-Truncate"
+)
+
+// 下游的 handler 可以标记请求不需要记录
+// 推荐用 caddyhttp.SetVar(r.Context(), SkipVar, true) 或者 vars zlog_skip true, 不会发给客户端
+// 也可以设置响应头 X-Zlog-Skip: 1, 但是这个头会发给客户端
+const (
+	SkipVar    = "zlog_skip"
+	SkipHeader = "X-Zlog-Skip"
+)
+
+// 日志输出格式
+const (
+	FormatText     = "text"
+	FormatJSON     = "json"
+	FormatCLF      = "clf"
+	FormatCombined = "combined"
 )
 
 func init() {
@@ -32,10 +105,226 @@ func init() {
 // ZLog 插件打印请求到特定目录
 // caddy 插件要求尽量大写
 type ZLog struct {
-	FileWriter logging.FileWriter
-	LogFile    io.WriteCloser
-	FileName   string
-	Truncate   uint64
+	FileWriter logging.FileWriter `json:"file_writer,omitempty"`
+	LogFile    io.WriteCloser     `json:"-"`
+	// FileName json 配置里 file_writer.filename 的简写, file_writer 没有设置文件名时使用
+	FileName string `json:"file_name,omitempty"`
+	Truncate uint64 `json:"truncate,omitempty"`
+	// StatusFiles 按状态码分类写到不同的文件, key 是 2xx 4xx 5xx 这样的分类
+	// 不属于这些分类的日志写到 FileWriter
+	StatusFiles map[string]*logging.FileWriter `json:"status_files,omitempty"`
+	// SlowThreshold 耗时超过这个阈值的请求带上 slow 标记
+	// SlowFile 慢请求额外写一份到这个文件, 滚动配置和 file_name 相同
+	SlowThreshold caddy.Duration      `json:"slow_threshold,omitempty"`
+	SlowFile      *logging.FileWriter `json:"slow_file,omitempty"`
+	// TruncateRequest TruncateResponse 分别覆盖请求和响应的 Truncate, 0 表示使用 Truncate
+	TruncateRequest  uint64 `json:"truncate_request,omitempty"`
+	TruncateResponse uint64 `json:"truncate_response,omitempty"`
+	// FullBodyBelow 小于这个大小的 body 完整记录, 否则只记录大小和 Content-Type, 设置后代替 Truncate
+	FullBodyBelow uint64 `json:"full_body_below,omitempty"`
+	// ErrorTruncate 5xx 响应使用这个截断大小, 比 TruncateResponse 小时不生效
+	ErrorTruncate uint64 `json:"error_truncate,omitempty"`
+	// MaxLine 整行日志的最大长度, 超出的部分截掉并加上标记, 0 不限制
+	MaxLine uint64 `json:"max_line,omitempty"`
+	// RollInterval 按时间滚动日志, 和 roll_size 哪个先到就先滚动
+	RollInterval caddy.Duration `json:"roll_interval,omitempty"`
+	// ReopenOnSignal 收到 SIGHUP 时重新打开日志文件, 配合外部的 logrotate 使用
+	ReopenOnSignal bool `json:"reopen_on_signal,omitempty"`
+	// Format 日志格式, text(默认) json clf 或 combined
+	Format string `json:"format,omitempty"`
+	// FormatTemplate 自定义日志格式, 例如 {ts} {method} {status} {path}, 设置后忽略 Format
+	// FormatTemplateStrict 模板中有不认识的占位符时报错, 否则原样输出
+	FormatTemplate       string `json:"format_template,omitempty"`
+	FormatTemplateStrict bool   `json:"format_template_strict,omitempty"`
+	// Fields 只按顺序输出这些字段, 例如 ts status method path duration_ms, 和 FormatTemplate 不能同时使用
+	Fields []string `json:"fields,omitempty"`
+	// LineSeparator 每行日志的结尾, 默认 \n, 例如 \r\n 或者 \x1e
+	LineSeparator string `json:"line_separator,omitempty"`
+	// TimeFormat 时间格式, 默认 rfc3339 (UTC)
+	TimeFormat string `json:"time_format,omitempty"`
+	// DisableQuery 不记录 query string, 避免 query 中的敏感信息落盘
+	DisableQuery bool `json:"disable_query,omitempty"`
+	// ClientIPHeader 从该请求头读取客户端 ip (如 X-Forwarded-For), 为空时使用 RemoteAddr
+	ClientIPHeader string `json:"client_ip_header,omitempty"`
+	// TrustForwarded scheme 优先使用 X-Forwarded-Proto, 只在对端是可信的代理时生效
+	// TrustedProxies 可信代理的 ip 或 CIDR, 为空时使用 caddy server 配置的 trusted_proxies
+	TrustForwarded bool     `json:"trust_forwarded,omitempty"`
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+	// RequestHeaders 需要记录的请求头
+	RequestHeaders []string `json:"request_headers,omitempty"`
+	// ResponseHeaders 需要记录的响应头
+	ResponseHeaders []string `json:"response_headers,omitempty"`
+	// LogCookies 记录这些 cookie, 值用 sha256 的前缀代替
+	LogCookies []string `json:"log_cookies,omitempty"`
+	// RedactHeaders 额外需要脱敏的 header, 总是包含 DefaultRedactHeaders
+	RedactHeaders []string `json:"redact_headers,omitempty"`
+	// RedactJSONFields json body 中需要脱敏的字段, 不区分大小写
+	RedactJSONFields []string `json:"redact_json_fields,omitempty"`
+	// RedactPatterns 替换 body 中匹配的内容, 可以是内置的 credit_card email ipv4, 也可以是正则
+	RedactPatterns []string `json:"redact_patterns,omitempty"`
+	// RouteName 这个 handler 所在路由的名字, 每条日志都带上, 区分大配置里不同路由的日志
+	RouteName string `json:"route_name,omitempty"`
+	// TraceHeader 从该请求头读取链路 id, 没有时生成一个并写回请求和响应头
+	TraceHeader string `json:"trace_header,omitempty"`
+	// OTel 从 W3C traceparent 读取 trace_id 和 span_id, 配置了 TraceHeader 时 trace_id 仍然使用 TraceHeader
+	// OTelGenerate 没有 traceparent 时生成新的 id 并注入到请求头, 隐含 OTel
+	OTel         bool `json:"otel,omitempty"`
+	OTelGenerate bool `json:"otel_generate,omitempty"`
+	// BodyHash 计算完整请求/响应 body 的摘要, md5 sha1 或 sha256, 不受 truncate 影响
+	BodyHash string `json:"body_hash,omitempty"`
+	// LogTLS 记录 tls 版本, 加密套件和 SNI
+	LogTLS bool `json:"log_tls,omitempty"`
+	// CaptureWhen 只有满足 caddy 表达式的请求才缓存 body, 其余请求仍然记录大小和状态码
+	// 例如 {path}.startsWith("/api/") && {method} == "POST"
+	CaptureWhen *caddyhttp.MatchExpression `json:"capture_when,omitempty"`
+	// RequestBodyOnError 仍然缓存请求体, 但只有响应不是 2xx 时才记录
+	RequestBodyOnError bool `json:"request_body_on_error,omitempty"`
+	// DisableRequestCapture DisableResponseCapture 不缓存请求/响应 body, 仍然记录大小
+	DisableRequestCapture  bool `json:"disable_request_capture,omitempty"`
+	DisableResponseCapture bool `json:"disable_response_capture,omitempty"`
+	// DisableJSONNormalize 文本格式下 json body 原样输出, 不再解析后重新序列化
+	// json 格式下 body 嵌入时本来就保留 key 的顺序, 不受影响
+	DisableJSONNormalize bool `json:"disable_json_normalize,omitempty"`
+	// PrettyJSON 文本格式下 json body 缩进两个空格输出, 只影响 body, json 格式不受影响
+	PrettyJSON bool `json:"pretty_json,omitempty"`
+	// GRPCDecode gRPC 请求和响应只记录每一帧的压缩标记和长度
+	GRPCDecode bool `json:"grpc_decode,omitempty"`
+	// BinaryEncoding 设置为 base64 时非文本的 body 编码后记录, 默认不记录
+	BinaryEncoding string `json:"binary_encoding,omitempty"`
+	// BodyContentTypes 只缓存这些 Content-Type 的 body, 支持 text/* 这样的通配
+	// 为空时使用 DefaultBodyContentTypes
+	BodyContentTypes []string `json:"body_content_types,omitempty"`
+	// ResponseBodyContentTypes 单独设置响应体的 Content-Type 白名单, 为空时和请求体一样使用 BodyContentTypes
+	// 响应的 Content-Type 在第一次写 body 时确定
+	ResponseBodyContentTypes []string `json:"response_body_content_types,omitempty"`
+	// LogUser 记录认证用户, 优先使用 caddy 认证模块的 {http.auth.user.id}, 其次是 basic auth 的用户名
+	LogUser bool `json:"log_user,omitempty"`
+	// LogUserAgent LogReferer 记录 User-Agent 和 Referer
+	LogUserAgent bool `json:"log_user_agent,omitempty"`
+	LogReferer   bool `json:"log_referer,omitempty"`
+	// LogDecompress 额外解码 br 和 zstd 响应体, gzip 和 deflate 总是会解码
+	LogDecompress bool `json:"log_decompress,omitempty"`
+	// MaxBufferMemory 所有请求缓存 body 的总内存上限, 超过后只统计大小不再缓存, 0 表示不限制
+	MaxBufferMemory uint64 `json:"max_buffer_memory,omitempty"`
+	// MaxBodyLog 响应的 Content-Length 超过这个大小时不缓存响应体, 只记录大小, 没有 Content-Length 时按截断大小缓存
+	MaxBodyLog uint64 `json:"max_body_log,omitempty"`
+	// BufferHint 请求体和响应体 buffer 的初始容量, body 大小比较固定时减少扩容, 不超过截断大小和 maxPooledBuffer
+	BufferHint uint64 `json:"buffer_hint,omitempty"`
+	// AllowTruncateHeader 允许客户端用 X-Zlog-Truncate 调小截断大小, 不能调大
+	AllowTruncateHeader bool `json:"truncate_header,omitempty"`
+	// LogUpstream 记录 reverse_proxy 实际使用的上游地址
+	LogUpstream bool `json:"log_upstream,omitempty"`
+	// Labels 每条日志都带上的固定字段, 值里的占位符在加载配置时解析, 例如 {env.HOSTNAME}
+	Labels map[string]string `json:"labels,omitempty"`
+	// LogTrailers 记录响应的 trailer, 例如 gRPC 的 grpc-status 和 grpc-message
+	LogTrailers bool `json:"log_trailers,omitempty"`
+	// GraphQLAware 请求体是 graphql 的 json 时记录 operationName 和压缩空白后的 query
+	GraphQLAware bool `json:"graphql_aware,omitempty"`
+	// LogRequestUUID 记录 caddy 给请求分配的 {http.request.uuid}, 用来和 caddy 自己的访问日志对应
+	LogRequestUUID bool `json:"log_request_uuid,omitempty"`
+	// LogCacheStatus 记录响应头里的缓存状态, 默认读取 RFC 9211 的 Cache-Status, CacheStatusHeader 可以改成 X-Cache 之类
+	LogCacheStatus    bool   `json:"log_cache_status,omitempty"`
+	CacheStatusHeader string `json:"cache_status_header,omitempty"`
+	// Status 只记录状态码在这些范围内的请求, 为空时全部记录
+	Status []StatusRange `json:"status,omitempty"`
+	// LevelMap 覆盖状态码对应的日志级别, key 是 404 这样的状态码或者 4xx 这样的分类, 值是 debug info warn error
+	// json 格式输出 level 字段, syslog 使用对应的严重级别
+	LevelMap map[string]string `json:"level_map,omitempty"`
+	// MatchPaths 只记录匹配的路径, SkipPaths 不记录匹配的路径, 两者都匹配时跳过
+	MatchPaths []string `json:"match_paths,omitempty"`
+	SkipPaths  []string `json:"skip_paths,omitempty"`
+	// Methods 只记录这些请求方法, 为空时全部记录
+	Methods []string `json:"methods,omitempty"`
+	// Sample 采样比例 (0, 1], 0 表示全部记录
+	// SampleAlwaysErrors 没有被采样的请求如果不是 2xx 也会记录, 但不记录 body
+	Sample             float64 `json:"sample,omitempty"`
+	SampleAlwaysErrors bool    `json:"sample_always_errors,omitempty"`
+	// RateLimit 每秒最多写多少条日志, 超出的直接丢弃并计入 dropped, 0 不限制
+	RateLimit int `json:"rate_limit,omitempty"`
+	// DisableStdout 不把日志复制到标准输出
+	DisableStdout bool `json:"disable_stdout,omitempty"`
+	// Stderr 同时把日志写到标准错误
+	Stderr bool `json:"stderr,omitempty"`
+	// Dedup 合并连续的重复日志 (方法, 路径, 状态码相同), 在 DedupWindow 内只输出一行并带上重复次数
+	Dedup       bool           `json:"dedup,omitempty"`
+	DedupWindow caddy.Duration `json:"dedup_window,omitempty"`
+	// StartLog 调用下游之前先写一行 started 日志, 卡住的请求也能看到, 两行日志用 trace_id 关联
+	// clf 和 combined 格式不支持
+	StartLog bool `json:"start_log,omitempty"`
+	// EncryptKeyFile EncryptKeyEnv 从文件或者环境变量读取 base64 编码的 AES key, 每行日志用 AES-GCM 整行加密
+	// 用 DecryptLine 解密
+	EncryptKeyFile string `json:"encrypt_key_file,omitempty"`
+	EncryptKeyEnv  string `json:"encrypt_key_env,omitempty"`
+	// Debug 对每个请求用 caddy 的 logger 记录是否写了日志以及原因, 排查过滤和采样的配置
+	Debug bool `json:"debug,omitempty"`
+	// Metrics 注册 prometheus 指标
+	Metrics bool `json:"metrics,omitempty"`
+	// Async 异步写日志, 队列满时丢弃并计数, 不阻塞请求
+	Async       bool `json:"async,omitempty"`
+	AsyncBuffer int  `json:"async_buffer,omitempty"`
+
+	// HTTPSink 把日志批量 POST 到这个地址
+	HTTPSink        string         `json:"http_sink,omitempty"`
+	HTTPSinkBatch   int            `json:"http_sink_batch,omitempty"`
+	HTTPSinkFlush   caddy.Duration `json:"http_sink_flush,omitempty"`
+	HTTPSinkTimeout caddy.Duration `json:"http_sink_timeout,omitempty"`
+
+	// BreakerFailures BreakerCooldown http_sink syslog 和 socket 连续失败这么多次之后熔断一段时间, 期间丢弃日志
+	BreakerFailures int            `json:"breaker_failures,omitempty"`
+	BreakerCooldown caddy.Duration `json:"breaker_cooldown,omitempty"`
+
+	// Socket 按行写到这个 unix domain socket, 断开时自动重连
+	Socket string `json:"socket,omitempty"`
+
+	// Syslog* 以 RFC 5424 格式发送到 syslog
+	SyslogNetwork  string `json:"syslog_network,omitempty"`
+	SyslogAddress  string `json:"syslog_address,omitempty"`
+	SyslogFacility string `json:"syslog_facility,omitempty"`
+	SyslogTag      string `json:"syslog_tag,omitempty"`
+
+	// Hooks 只对这个实例生效的 EntryHook, 只能在 go 代码里设置
+	Hooks []EntryHook `json:"-"`
+
+	logger *zap.Logger
+	// ctx 模块的生命周期, 配置被替换或者 caddy 退出时取消
+	ctx         context.Context
+	stopRolling chan struct{}
+	// stopReopening 停止监听 SIGHUP
+	stopReopening chan struct{}
+	template      []templateSegment
+	redactRegex   []*regexp.Regexp
+	// aead 配置了 encrypt 时用于加密每行日志
+	aead cipher.AEAD
+
+	// sinks 所有的日志输出, 在 Provision 里创建
+	sinks []Sink
+	// slowSink slow_file 的输出, 只写入慢请求
+	slowSink Sink
+	// outputMu 保护 sinks slowSink 和 closed, Cleanup 之后 closed, 还没结束的请求写的日志直接丢弃
+	outputMu sync.Mutex
+	closed   bool
+
+	dedupMu sync.Mutex
+	pending *dedupState
+
+	queue   chan logLine
+	queueMu sync.RWMutex
+	done    chan struct{}
+	dropped atomic.Uint64
+	// trustedProxies 解析后的 TrustedProxies
+	trustedProxies []netip.Prefix
+	// labels 解析过占位符的 Labels
+	labels map[string]string
+	// limiter rate_limit 的令牌桶, rateLimited 因为限速丢弃的条数
+	limiter     *rate.Limiter
+	rateLimited atomic.Uint64
+
+	// 给 admin 接口 /zlog/status 使用的统计, 不依赖 metrics
+	written      atomic.Uint64
+	writtenBytes atomic.Uint64
+	writeErrors  atomic.Uint64
+	unhealthy    atomic.Bool
+	lastError    atomic.Value
 }
 
 func (z *ZLog) CaddyModule() caddy.ModuleInfo {
@@ -59,19 +348,577 @@ func (z *ZLog) UnmarshalCaddyfile(d *caddyfile.Dispenser) (err error) {
 				if !d.AllArgs(&fw.Filename) {
 					return d.ArgErr()
 				}
-				z.FileName = fw.Filename
+			case "file_1xx", "file_2xx", "file_3xx", "file_4xx", "file_5xx":
+				class := strings.TrimPrefix(d.Val(), "file_")
+				var name string
+				if !d.AllArgs(&name) {
+					return d.ArgErr()
+				}
+				if z.StatusFiles == nil {
+					z.StatusFiles = make(map[string]*logging.FileWriter)
+				}
+				z.StatusFiles[class] = &logging.FileWriter{Filename: name}
+			case "slow_threshold":
+				var durStr string
+				if !d.AllArgs(&durStr) {
+					return d.ArgErr()
+				}
+				dur, err := caddy.ParseDuration(durStr)
+				if err != nil || dur <= 0 {
+					return d.Errf("parsing slow_threshold duration: %s", durStr)
+				}
+				z.SlowThreshold = caddy.Duration(dur)
+			case "slow_file":
+				var name string
+				if !d.AllArgs(&name) {
+					return d.ArgErr()
+				}
+				z.SlowFile = &logging.FileWriter{Filename: name}
 			case "roll_disabled":
 				var f bool
 				fw.Roll = &f
 				if d.NextArg() {
 					return d.ArgErr()
 				}
+			case "reopen_on_signal":
+				if z.ReopenOnSignal, err = parseOnOff(d); err != nil {
+					return err
+				}
 			case "truncate":
 				var sizeStr string
 				if !d.AllArgs(&sizeStr) {
 					return d.ArgErr()
 				}
 				z.Truncate, _ = humanize.ParseBytes(sizeStr)
+			case "truncate_request", "truncate_response":
+				name := d.Val()
+				var sizeStr string
+				if !d.AllArgs(&sizeStr) {
+					return d.ArgErr()
+				}
+				size, err := humanize.ParseBytes(sizeStr)
+				if err != nil {
+					return d.Errf("parsing %s: %v", name, err)
+				}
+				if name == "truncate_request" {
+					z.TruncateRequest = size
+				} else {
+					z.TruncateResponse = size
+				}
+			case "full_body_below":
+				var sizeStr string
+				if !d.AllArgs(&sizeStr) {
+					return d.ArgErr()
+				}
+				size, err := humanize.ParseBytes(sizeStr)
+				if err != nil || size == 0 {
+					return d.Errf("parsing full_body_below: %s", sizeStr)
+				}
+				z.FullBodyBelow = size
+			case "max_line":
+				var sizeStr string
+				if !d.AllArgs(&sizeStr) {
+					return d.ArgErr()
+				}
+				size, err := humanize.ParseBytes(sizeStr)
+				if err != nil || size == 0 {
+					return d.Errf("parsing max_line: %s", sizeStr)
+				}
+				z.MaxLine = size
+			case "max_body_log":
+				var sizeStr string
+				if !d.AllArgs(&sizeStr) {
+					return d.ArgErr()
+				}
+				size, err := humanize.ParseBytes(sizeStr)
+				if err != nil || size == 0 {
+					return d.Errf("parsing max_body_log: %s", sizeStr)
+				}
+				z.MaxBodyLog = size
+			case "buffer_hint":
+				var sizeStr string
+				if !d.AllArgs(&sizeStr) {
+					return d.ArgErr()
+				}
+				size, err := humanize.ParseBytes(sizeStr)
+				if err != nil || size == 0 {
+					return d.Errf("parsing buffer_hint: %s", sizeStr)
+				}
+				z.BufferHint = size
+			case "error_truncate":
+				var sizeStr string
+				if !d.AllArgs(&sizeStr) {
+					return d.ArgErr()
+				}
+				size, err := humanize.ParseBytes(sizeStr)
+				if err != nil {
+					return d.Errf("parsing error_truncate: %v", err)
+				}
+				z.ErrorTruncate = size
+			case "format":
+				if !d.AllArgs(&z.Format) {
+					return d.ArgErr()
+				}
+				switch z.Format {
+				case FormatText, FormatJSON, FormatCLF, FormatCombined:
+				default:
+					return d.Errf("unknown format: %s", z.Format)
+				}
+			case "format_template":
+				args := d.RemainingArgs()
+				if len(args) == 0 || len(args) > 2 {
+					return d.ArgErr()
+				}
+				z.FormatTemplate = args[0]
+				if len(args) == 2 {
+					if args[1] != "strict" {
+						return d.Errf("unknown format_template option: %s", args[1])
+					}
+					z.FormatTemplateStrict = true
+				}
+				if _, err := parseTemplate(z.FormatTemplate, z.FormatTemplateStrict); err != nil {
+					return d.Errf("parsing format_template: %v", err)
+				}
+			case "fields":
+				z.Fields = append(z.Fields, d.RemainingArgs()...)
+				if len(z.Fields) == 0 {
+					return d.ArgErr()
+				}
+			case "line_separator":
+				var sep string
+				if !d.AllArgs(&sep) {
+					return d.ArgErr()
+				}
+				// Caddyfile 不处理转义, 这里按 go 字符串的规则解析 \r\n \x1e 之类
+				if z.LineSeparator, err = strconv.Unquote(`"` + sep + `"`); err != nil || z.LineSeparator == "" {
+					return d.Errf("invalid line_separator: %s", sep)
+				}
+			case "time_format":
+				if !d.AllArgs(&z.TimeFormat) {
+					return d.ArgErr()
+				}
+			case "log_query":
+				on, err := parseOnOff(d)
+				if err != nil {
+					return err
+				}
+				z.DisableQuery = !on
+			case "trust_forwarded":
+				if z.TrustForwarded, err = parseOnOff(d); err != nil {
+					return err
+				}
+			case "trusted_proxies":
+				z.TrustedProxies = append(z.TrustedProxies, d.RemainingArgs()...)
+				if len(z.TrustedProxies) == 0 {
+					return d.ArgErr()
+				}
+			case "route_name":
+				if !d.AllArgs(&z.RouteName) {
+					return d.ArgErr()
+				}
+			case "otel":
+				if z.OTel, err = parseOnOff(d); err != nil {
+					return err
+				}
+			case "otel_generate":
+				if z.OTelGenerate, err = parseOnOff(d); err != nil {
+					return err
+				}
+			case "client_ip_header":
+				if !d.AllArgs(&z.ClientIPHeader) {
+					return d.ArgErr()
+				}
+			case "log_request_headers":
+				z.RequestHeaders = append(z.RequestHeaders, d.RemainingArgs()...)
+				if len(z.RequestHeaders) == 0 {
+					return d.ArgErr()
+				}
+			case "log_response_headers":
+				z.ResponseHeaders = append(z.ResponseHeaders, d.RemainingArgs()...)
+				if len(z.ResponseHeaders) == 0 {
+					return d.ArgErr()
+				}
+			case "log_cookies":
+				z.LogCookies = append(z.LogCookies, d.RemainingArgs()...)
+				if len(z.LogCookies) == 0 {
+					return d.ArgErr()
+				}
+			case "redact_headers":
+				z.RedactHeaders = append(z.RedactHeaders, d.RemainingArgs()...)
+				if len(z.RedactHeaders) == 0 {
+					return d.ArgErr()
+				}
+			case "redact_json_fields":
+				z.RedactJSONFields = append(z.RedactJSONFields, d.RemainingArgs()...)
+				if len(z.RedactJSONFields) == 0 {
+					return d.ArgErr()
+				}
+			case "redact_pattern":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				for _, arg := range args {
+					if _, err := compileRedactPattern(arg); err != nil {
+						return d.Errf("parsing redact_pattern %s: %v", arg, err)
+					}
+				}
+				z.RedactPatterns = append(z.RedactPatterns, args...)
+			case "trace_header":
+				if !d.AllArgs(&z.TraceHeader) {
+					return d.ArgErr()
+				}
+			case "body_hash":
+				if !d.AllArgs(&z.BodyHash) {
+					return d.ArgErr()
+				}
+				if _, ok := bodyHashes[z.BodyHash]; !ok {
+					return d.Errf("unknown body_hash: %s", z.BodyHash)
+				}
+			case "log_tls":
+				if z.LogTLS, err = parseOnOff(d); err != nil {
+					return err
+				}
+			case "request_body_on_error":
+				if z.RequestBodyOnError, err = parseOnOff(d); err != nil {
+					return err
+				}
+			case "truncate_header":
+				if z.AllowTruncateHeader, err = parseOnOff(d); err != nil {
+					return err
+				}
+			case "capture_request":
+				on, err := parseOnOff(d)
+				if err != nil {
+					return err
+				}
+				z.DisableRequestCapture = !on
+			case "capture_response":
+				on, err := parseOnOff(d)
+				if err != nil {
+					return err
+				}
+				z.DisableResponseCapture = !on
+			case "normalize_json":
+				on, err := parseOnOff(d)
+				if err != nil {
+					return err
+				}
+				z.DisableJSONNormalize = !on
+			case "pretty_json":
+				if z.PrettyJSON, err = parseOnOff(d); err != nil {
+					return err
+				}
+			case "grpc_decode":
+				if z.GRPCDecode, err = parseOnOff(d); err != nil {
+					return err
+				}
+			case "binary_encoding":
+				if !d.AllArgs(&z.BinaryEncoding) {
+					return d.ArgErr()
+				}
+				if z.BinaryEncoding != BinaryEncodingBase64 {
+					return d.Errf("unknown binary_encoding: %s", z.BinaryEncoding)
+				}
+			case "capture_when":
+				var expr string
+				switch n := d.CountRemainingArgs(); {
+				case n == 0:
+					return d.ArgErr()
+				case n == 1:
+					d.NextArg()
+					expr = d.Val()
+				default:
+					expr = strings.Join(d.RemainingArgsRaw(), " ")
+				}
+				z.CaptureWhen = &caddyhttp.MatchExpression{Expr: expr}
+			case "body_content_types":
+				z.BodyContentTypes = append(z.BodyContentTypes, d.RemainingArgs()...)
+				if len(z.BodyContentTypes) == 0 {
+					return d.ArgErr()
+				}
+			case "response_body_content_types":
+				z.ResponseBodyContentTypes = append(z.ResponseBodyContentTypes, d.RemainingArgs()...)
+				if len(z.ResponseBodyContentTypes) == 0 {
+					return d.ArgErr()
+				}
+			case "log_user":
+				if z.LogUser, err = parseOnOff(d); err != nil {
+					return err
+				}
+			case "log_user_agent":
+				if z.LogUserAgent, err = parseOnOff(d); err != nil {
+					return err
+				}
+			case "log_referer":
+				if z.LogReferer, err = parseOnOff(d); err != nil {
+					return err
+				}
+			case "log_decompress":
+				if z.LogDecompress, err = parseOnOff(d); err != nil {
+					return err
+				}
+			case "max_buffer_memory":
+				var sizeStr string
+				if !d.AllArgs(&sizeStr) {
+					return d.ArgErr()
+				}
+				size, err := humanize.ParseBytes(sizeStr)
+				if err != nil {
+					return d.Errf("parsing max_buffer_memory: %v", err)
+				}
+				z.MaxBufferMemory = size
+			case "log_upstream":
+				if z.LogUpstream, err = parseOnOff(d); err != nil {
+					return err
+				}
+			case "labels":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				if z.Labels == nil {
+					z.Labels = make(map[string]string)
+				}
+				for _, arg := range args {
+					key, value, ok := parseLabel(arg)
+					if !ok {
+						return d.Errf("label must be key=value: %s", arg)
+					}
+					z.Labels[key] = value
+				}
+			case "log_trailers":
+				if z.LogTrailers, err = parseOnOff(d); err != nil {
+					return err
+				}
+			case "graphql_aware":
+				if z.GraphQLAware, err = parseOnOff(d); err != nil {
+					return err
+				}
+			case "log_request_uuid":
+				if z.LogRequestUUID, err = parseOnOff(d); err != nil {
+					return err
+				}
+			case "log_cache_status":
+				if z.LogCacheStatus, err = parseOnOff(d); err != nil {
+					return err
+				}
+			case "cache_status_header":
+				if !d.AllArgs(&z.CacheStatusHeader) {
+					return d.ArgErr()
+				}
+			case "level_map":
+				args := d.RemainingArgs()
+				if len(args) == 0 || len(args)%2 != 0 {
+					return d.ArgErr()
+				}
+				if z.LevelMap == nil {
+					z.LevelMap = make(map[string]string)
+				}
+				for i := 0; i < len(args); i += 2 {
+					z.LevelMap[args[i]] = args[i+1]
+				}
+				if err := validateLevelMap(z.LevelMap); err != nil {
+					return d.Err(err.Error())
+				}
+			case "status":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				for _, arg := range args {
+					sr, err := parseStatusRange(arg)
+					if err != nil {
+						return d.Errf("parsing status %s: %v", arg, err)
+					}
+					z.Status = append(z.Status, sr)
+				}
+			case "match_path":
+				z.MatchPaths = append(z.MatchPaths, d.RemainingArgs()...)
+				if len(z.MatchPaths) == 0 {
+					return d.ArgErr()
+				}
+			case "skip_path":
+				z.SkipPaths = append(z.SkipPaths, d.RemainingArgs()...)
+				if len(z.SkipPaths) == 0 {
+					return d.ArgErr()
+				}
+			case "methods":
+				args := d.RemainingArgs()
+				if len(args) == 0 {
+					return d.ArgErr()
+				}
+				for _, m := range args {
+					z.Methods = append(z.Methods, strings.ToUpper(m))
+				}
+			case "sample":
+				args := d.RemainingArgs()
+				if len(args) == 0 || len(args) > 2 {
+					return d.ArgErr()
+				}
+				fraction, err := strconv.ParseFloat(args[0], 64)
+				if err != nil || fraction <= 0 || fraction > 1 {
+					return d.Errf("sample must be a fraction in (0, 1]: %s", args[0])
+				}
+				z.Sample = fraction
+				if len(args) == 2 {
+					if args[1] != "always_errors" {
+						return d.Errf("unknown sample option: %s", args[1])
+					}
+					z.SampleAlwaysErrors = true
+				}
+			case "http_sink":
+				if !d.AllArgs(&z.HTTPSink) {
+					return d.ArgErr()
+				}
+			case "http_sink_batch":
+				var sizeStr string
+				if !d.AllArgs(&sizeStr) {
+					return d.ArgErr()
+				}
+				size, err := strconv.Atoi(sizeStr)
+				if err != nil || size <= 0 {
+					return d.Errf("parsing http_sink_batch: %s", sizeStr)
+				}
+				z.HTTPSinkBatch = size
+			case "http_sink_flush", "http_sink_timeout":
+				name := d.Val()
+				var durStr string
+				if !d.AllArgs(&durStr) {
+					return d.ArgErr()
+				}
+				dur, err := caddy.ParseDuration(durStr)
+				if err != nil || dur <= 0 {
+					return d.Errf("parsing %s duration: %s", name, durStr)
+				}
+				if name == "http_sink_flush" {
+					z.HTTPSinkFlush = caddy.Duration(dur)
+				} else {
+					z.HTTPSinkTimeout = caddy.Duration(dur)
+				}
+			case "rate_limit":
+				var nStr string
+				if !d.AllArgs(&nStr) {
+					return d.ArgErr()
+				}
+				n, err := strconv.Atoi(nStr)
+				if err != nil || n <= 0 {
+					return d.Errf("parsing rate_limit: %s", nStr)
+				}
+				z.RateLimit = n
+			case "breaker_failures":
+				var nStr string
+				if !d.AllArgs(&nStr) {
+					return d.ArgErr()
+				}
+				n, err := strconv.Atoi(nStr)
+				if err != nil || n <= 0 {
+					return d.Errf("parsing breaker_failures: %s", nStr)
+				}
+				z.BreakerFailures = n
+			case "breaker_cooldown":
+				var durStr string
+				if !d.AllArgs(&durStr) {
+					return d.ArgErr()
+				}
+				dur, err := caddy.ParseDuration(durStr)
+				if err != nil || dur <= 0 {
+					return d.Errf("parsing breaker_cooldown duration: %s", durStr)
+				}
+				z.BreakerCooldown = caddy.Duration(dur)
+			case "syslog_network":
+				if !d.AllArgs(&z.SyslogNetwork) {
+					return d.ArgErr()
+				}
+				switch z.SyslogNetwork {
+				case "udp", "tcp", "unix":
+				default:
+					return d.Errf("unknown syslog_network: %s", z.SyslogNetwork)
+				}
+			case "socket":
+				if !d.AllArgs(&z.Socket) {
+					return d.ArgErr()
+				}
+			case "syslog_address":
+				if !d.AllArgs(&z.SyslogAddress) {
+					return d.ArgErr()
+				}
+			case "syslog_facility":
+				if !d.AllArgs(&z.SyslogFacility) {
+					return d.ArgErr()
+				}
+				if _, ok := syslogFacilities[z.SyslogFacility]; !ok {
+					return d.Errf("unknown syslog_facility: %s", z.SyslogFacility)
+				}
+			case "syslog_tag":
+				if !d.AllArgs(&z.SyslogTag) {
+					return d.ArgErr()
+				}
+			case "stdout":
+				on, err := parseOnOff(d)
+				if err != nil {
+					return err
+				}
+				z.DisableStdout = !on
+			case "stderr":
+				if z.Stderr, err = parseOnOff(d); err != nil {
+					return err
+				}
+			case "dedup":
+				if z.Dedup, err = parseOnOff(d); err != nil {
+					return err
+				}
+			case "dedup_window":
+				var durStr string
+				if !d.AllArgs(&durStr) {
+					return d.ArgErr()
+				}
+				dur, err := caddy.ParseDuration(durStr)
+				if err != nil || dur <= 0 {
+					return d.Errf("parsing dedup_window duration: %s", durStr)
+				}
+				z.DedupWindow = caddy.Duration(dur)
+			case "start_log":
+				if z.StartLog, err = parseOnOff(d); err != nil {
+					return err
+				}
+			case "encrypt":
+				var source, value string
+				if !d.AllArgs(&source, &value) {
+					return d.ArgErr()
+				}
+				switch source {
+				case "file":
+					z.EncryptKeyFile = value
+				case "env":
+					z.EncryptKeyEnv = value
+				default:
+					return d.Errf("encrypt key source must be file or env, got %s", source)
+				}
+			case "debug":
+				z.Debug = true
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+			case "metrics":
+				if z.Metrics, err = parseOnOff(d); err != nil {
+					return err
+				}
+			case "async":
+				z.Async = true
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+			case "async_buffer":
+				var sizeStr string
+				if !d.AllArgs(&sizeStr) {
+					return d.ArgErr()
+				}
+				size, err := strconv.Atoi(sizeStr)
+				if err != nil || size <= 0 {
+					return d.Errf("parsing async_buffer: %s", sizeStr)
+				}
+				z.AsyncBuffer = size
 			case "roll_size":
 				var sizeStr string
 				if !d.AllArgs(&sizeStr) {
@@ -83,6 +930,20 @@ func (z *ZLog) UnmarshalCaddyfile(d *caddyfile.Dispenser) (err error) {
 				}
 				fw.RollSizeMB = int(math.Ceil(float64(size) / humanize.MiByte))
 
+			case "roll_interval":
+				var intervalStr string
+				if !d.AllArgs(&intervalStr) {
+					return d.ArgErr()
+				}
+				interval, err := caddy.ParseDuration(intervalStr)
+				if err != nil {
+					return d.Errf("parsing roll_interval duration: %v", err)
+				}
+				if interval <= 0 {
+					return d.Errf("non-positive roll_interval duration: %v", interval)
+				}
+				z.RollInterval = caddy.Duration(interval)
+
 			case "roll_uncompressed":
 				var f bool
 				fw.RollCompress = &f
@@ -123,116 +984,1288 @@ func (z *ZLog) UnmarshalCaddyfile(d *caddyfile.Dispenser) (err error) {
 			}
 		}
 	}
+	// 按状态码分类的文件使用和 file_name 相同的滚动配置
+	for _, sfw := range z.StatusFiles {
+		name := sfw.Filename
+		*sfw = *fw
+		sfw.Filename = name
+	}
+	if z.SlowFile != nil {
+		name := z.SlowFile.Filename
+		*z.SlowFile = *fw
+		z.SlowFile.Filename = name
+	}
 	if z.Truncate == 0 {
 		z.Truncate = DefaultTruncate
 	}
-	z.printCfg()
 	return nil
 }
 
+// parseOnOff 解析 on/off 参数
+func parseOnOff(d *caddyfile.Dispenser) (bool, error) {
+	var v string
+	if !d.AllArgs(&v) {
+		return false, d.ArgErr()
+	}
+	switch v {
+	case "on":
+		return true, nil
+	case "off":
+		return false, nil
+	}
+	return false, d.Errf("expect on or off, got %s", v)
+}
+
 type proxyWriter struct {
 	http.ResponseWriter
-	respBuf  bytes.Buffer
+	respBuf  *bytes.Buffer
 	respSize int
 
 	code int
 	req  *http.Request
 	body io.ReadCloser
 
-	reqBuf  bytes.Buffer
+	reqBuf  *bytes.Buffer
 	reqSize int
 
-	truncate int
+	// reqHash respHash 配置了 body_hash 时对完整的 body 求摘要
+	reqHash  hash.Hash
+	respHash hash.Hash
+	// reqFrames respFrames 开启 grpc_decode 时记录 gRPC 的帧信息
+	reqFrames  *grpcFrames
+	respFrames *grpcFrames
+
+	reqTruncate  int
+	respTruncate int
+	// normalTruncate 开启 error_truncate 时非 5xx 响应的截断大小
+	// 状态码可能在响应中途才确定, 所以先按较大的 respTruncate 缓存, 写日志前再裁剪
+	normalTruncate int
+	z              *ZLog
+
+	// respSize 响应体的字节数, 响应体只有两条路径:
+	// Write 统计实际写出的 n, ReadFrom 的前半段走 Write, 后半段统计底层 ReadFrom 的返回值
+	// Flush 只下发已经写出的数据, 不会改变大小, 连接被 Hijack 之后写的数据不统计
+	hijacked bool
+	// reserved 从 max_buffer_memory 预留的字节数, writeLog 之后释放
+	// Read 和 Write 可能在不同的 goroutine 里
+	reserved    atomic.Int64
+	wroteHeader bool
+	// 不缓存 body 时仍然统计大小
+	skipReqBody  bool
+	skipRespBody bool
+	respChecked  bool
+	traceID      string
+	// spanID traceparent 里的 span id, 开启 otel 时才有
+	spanID string
+	// start 开始处理请求的时间, reqDone 请求体读完的时间 (UnixNano, Read 可能在其他 goroutine), firstByte 发出响应头的时间
+	start     time.Time
+	reqDone   atomic.Int64
+	firstByte time.Time
+	// clientDisconnected 请求结束时 context 已经取消, 通常是客户端中途断开
+	clientDisconnected bool
+	// respHeader 响应头发出时的快照, 之后再修改 Header() 不会发给客户端
+	respHeader http.Header
+	// readMu 保护请求体的统计和捕获, handler 返回后 readDone, 之后其他 goroutine 的 Read 只透传
+	// 否则 buffer 还回池子之后还会被写入
+	readMu   sync.Mutex
+	readDone bool
 }
 
 func (pw *proxyWriter) Read(p []byte) (n int, err error) {
 	n, err = pw.body.Read(p)
+	pw.readMu.Lock()
+	defer pw.readMu.Unlock()
+	if pw.readDone {
+		return
+	}
 	pw.reqSize += n
-	pw.reqBuf.Write(p[:pw.min(pw.truncate-pw.reqBuf.Len(), n)])
+	if err == io.EOF && pw.reqDone.Load() == 0 {
+		pw.reqDone.Store(time.Now().UnixNano())
+	}
+	if pw.reqHash != nil {
+		pw.reqHash.Write(p[:n])
+	}
+	if pw.reqFrames != nil {
+		pw.reqFrames.Write(p[:n])
+	}
+	if !pw.skipReqBody {
+		pw.reqBuf.Write(p[:pw.capLen(pw.reqBuf, n, pw.reqTruncate)])
+	}
 	return
 }
 
-func (z *ZLog) printCfg() {
-	data, _ := json.Marshal(z.FileWriter)
-	fmt.Printf("zlog cfg = %s\n", data)
+// stopRead handler 返回后请求体的捕获就固定下来, 之后的 Read 不再修改 reqBuf 和统计
+func (pw *proxyWriter) stopRead() {
+	pw.readMu.Lock()
+	pw.readDone = true
+	pw.readMu.Unlock()
 }
 
 func (pw *proxyWriter) Close() error {
 	return pw.body.Close()
 }
 
+// WriteHeader 和 net/http 一样只有第一次调用生效, 重复调用直接忽略
 func (p *proxyWriter) WriteHeader(statusCode int) {
+	if p.wroteHeader {
+		return
+	}
+	// 1xx 信息性响应之后还会有最终响应头
+	if statusCode < 200 && statusCode != http.StatusSwitchingProtocols {
+		p.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	p.wroteHeader = true
+	p.firstByte = time.Now()
+	p.snapshotHeader()
+	// 101 之后写出的是新协议的数据, 不是响应体, 只统计字节数
+	if statusCode == http.StatusSwitchingProtocols {
+		p.respChecked = true
+		p.skipRespBody = true
+		p.respHash = nil
+		p.respFrames = nil
+	}
 	p.ResponseWriter.WriteHeader(statusCode)
 	p.code = statusCode
 }
 
+// upgraded 连接被接管或者已经切换协议 (websocket, h2c 等), 没有常规意义上的响应体
+func (p *proxyWriter) upgraded() bool {
+	return p.hijacked || p.code == http.StatusSwitchingProtocols
+}
+
+// Flush 透传给底层 ResponseWriter, 保证 SSE 等流式响应能及时下发
+func (p *proxyWriter) Flush() {
+	if f, ok := p.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap 给 http.ResponseController 使用, 例如 SetWriteDeadline
+// ResponseController 只做控制, 不会绕过 proxyWriter 写数据, 不影响 respSize
+func (p *proxyWriter) Unwrap() http.ResponseWriter {
+	return p.ResponseWriter
+}
+
+// Hijack 透传给底层 ResponseWriter, 用于 websocket 等协议升级
+func (p *proxyWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := p.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, fmt.Errorf("zlog: underlying ResponseWriter does not implement http.Hijacker")
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil {
+		p.hijacked = true
+	}
+	return conn, rw, err
+}
+
 func (p *proxyWriter) min(x, y int) int {
 	if x < y {
 		return x
 	}
 	return y
 }
+
+// capLen 计算本次还能写入 buf 的字节数, buf 已满时返回 0 而不是负数
+func (p *proxyWriter) capLen(buf *bytes.Buffer, n, truncate int) int {
+	n = p.min(n, truncate-buf.Len())
+	if n <= 0 {
+		return 0
+	}
+	return p.reserve(n)
+}
+
+func (p *proxyWriter) snapshotHeader() {
+	if p.respHeader == nil {
+		p.respHeader = p.ResponseWriter.Header().Clone()
+	}
+}
+
+// header 实际发给客户端的响应头
+func (p *proxyWriter) header() http.Header {
+	if p.respHeader != nil {
+		return p.respHeader
+	}
+	return p.ResponseWriter.Header()
+}
+
+// beginBody 没有调用 WriteHeader 时第一次写 body 会隐式发出 200 响应头
+// 响应的 Content-Type 也要到这时才能确定
+func (p *proxyWriter) beginBody() {
+	if !p.wroteHeader {
+		p.firstByte = time.Now()
+	}
+	p.wroteHeader = true
+	p.snapshotHeader()
+	if p.z.GRPCDecode && p.respFrames == nil && isGRPC(p.header().Get("Content-Type")) {
+		p.respFrames = &grpcFrames{}
+	}
+	if !p.respChecked {
+		p.respChecked = true
+		p.skipRespBody = !p.z.captureRespContentType(p.header().Get("Content-Type")) || p.overMaxBodyLog()
+	}
+}
+
 func (p *proxyWriter) Write(data []byte) (n int, err error) {
+	p.beginBody()
 	n, err = p.ResponseWriter.Write(data)
 	p.respSize += n
-	p.respBuf.Write(data[:p.min(len(data), p.truncate-p.respBuf.Len())])
+	if p.respHash != nil {
+		p.respHash.Write(data[:n])
+	}
+	if p.respFrames != nil {
+		p.respFrames.Write(data[:n])
+	}
+	if !p.skipRespBody {
+		p.respBuf.Write(data[:p.capLen(p.respBuf, n, p.respTruncate)])
+	}
 	return
 }
 
-func (p *proxyWriter) tryToJson(buf bytes.Buffer) (out string) {
-	bytes := buf.Bytes()
-    // 发现非 ascii 字符 
-	for i := range bytes {
-		if bytes[i] > 127 {
+// overMaxBodyLog 响应头里声明的 Content-Length 超过 max_body_log, 整个响应都不缓存, 只记录大小
+// 分块传输没有 Content-Length, 仍然按截断大小缓存
+func (p *proxyWriter) overMaxBodyLog() bool {
+	if p.z.MaxBodyLog == 0 {
+		return false
+	}
+	cl, err := strconv.ParseUint(p.header().Get("Content-Length"), 10, 64)
+	return err == nil && cl > p.z.MaxBodyLog
+}
+
+// writerOnly 隐藏 proxyWriter 的 ReadFrom, 避免 io.Copy 递归调用
+type writerOnly struct {
+	io.Writer
+}
+
+// ReadFrom 让底层 ResponseWriter 可以使用 sendfile 之类的优化
+// 需要缓存的前 respTruncate 个字节走 Write, 剩下的部分直接交给底层的 ReadFrom, 只统计大小
+// Content-Length 已经超过 respTruncate 时整个响应都不缓存
+// 开启 body_hash 或者 grpc_decode 时剩下的部分也要经过它们, 这时用不上 sendfile
+func (p *proxyWriter) ReadFrom(src io.Reader) (n int64, err error) {
+	p.beginBody()
+	rf, ok := p.ResponseWriter.(io.ReaderFrom)
+	if !ok {
+		return io.Copy(writerOnly{p}, src)
+	}
+	if cl, err := strconv.Atoi(p.header().Get("Content-Length")); err == nil && cl > p.respTruncate {
+		p.skipRespBody = true
+	}
+	if remain := int64(p.respTruncate - p.respBuf.Len()); !p.skipRespBody && remain > 0 {
+		n, err = io.Copy(writerOnly{p}, io.LimitReader(src, remain))
+		if err != nil || n < remain {
 			return
 		}
 	}
+	if p.respHash != nil {
+		src = io.TeeReader(src, p.respHash)
+	}
+	if p.respFrames != nil {
+		src = io.TeeReader(src, p.respFrames)
+	}
+	m, err := rf.ReadFrom(src)
+	p.respSize += int(m)
+	return n + m, err
+}
+
+// textBytes 判断是否是 utf8 文本, 截断时可能切断最后一个字符, 先去掉不完整的尾部
+func textBytes(data []byte) ([]byte, bool) {
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				data = data[:i]
+			}
+			break
+		}
+	}
+	return data, utf8.Valid(data)
+}
+
+// marshalJSON 不转义 html 字符, 也不会把中文等多字节字符转成 \uXXXX
+func marshalJSON(v interface{}) []byte {
+	return marshalIndentJSON(v, "")
+}
+
+// marshalIndentJSON indent 为空时输出紧凑的 json
+func marshalIndentJSON(v interface{}, indent string) []byte {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", indent)
+	enc.Encode(v)
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n"))
+}
+
+func (p *proxyWriter) tryToJson(buf *bytes.Buffer) (out string) {
+	bytes, ok := textBytes(buf.Bytes())
+	// 非文本内容
+	if !ok {
+		if p.base64Body(buf) {
+			return BinaryEncodingBase64 + ":" + base64.StdEncoding.EncodeToString(buf.Bytes())
+		}
+		return
+	}
 	out = string(bytes)
 	var (
 		jsonObj interface{}
 		err     error
 	)
-
+	// 不需要规范化时原样输出, 保留 key 的顺序和空白, 也省掉解析的开销
+	// 需要脱敏字段或者缩进时仍然要解析
+	if p.z.DisableJSONNormalize && len(p.z.RedactJSONFields) == 0 && !p.z.PrettyJSON {
+		return p.z.maskPatterns(strings.ReplaceAll(out, "\n", "\\n"))
+	}
 	if err = json.Unmarshal([]byte(out), &jsonObj); err != nil {
-		return strings.ReplaceAll(out, "\n", "\\n")
+		return p.z.maskPatterns(strings.ReplaceAll(out, "\n", "\\n"))
+	}
+	// body 已经按 truncate 截断, 缩进只会让完整的 json 变长
+	indent := ""
+	if p.z.PrettyJSON {
+		indent = "  "
+	}
+	return p.z.maskPatterns(string(marshalIndentJSON(p.z.redactJSON(jsonObj), indent)))
+}
+
+// jsonBody 合法的 json 直接嵌入, 否则作为字符串
+func (p *proxyWriter) jsonBody(buf *bytes.Buffer) interface{} {
+	var raw []byte
+	if len(p.z.RedactJSONFields) > 0 {
+		var jsonObj interface{}
+		if err := json.Unmarshal(buf.Bytes(), &jsonObj); err == nil {
+			raw = marshalJSON(p.z.redactJSON(jsonObj))
+		}
+	} else {
+		var out bytes.Buffer
+		if err := json.Compact(&out, buf.Bytes()); err == nil {
+			raw = out.Bytes()
+		}
+	}
+	if raw != nil {
+		// 正则替换之后可能不再是合法的 json, 这时作为字符串
+		masked := []byte(p.z.maskPatterns(string(raw)))
+		if json.Valid(masked) {
+			return json.RawMessage(masked)
+		}
+		return string(masked)
+	}
+	data, ok := textBytes(buf.Bytes())
+	if !ok {
+		if p.base64Body(buf) {
+			return base64.StdEncoding.EncodeToString(buf.Bytes())
+		}
+		return ""
+	}
+	return p.z.maskPatterns(string(data))
+}
+
+// truncatedMarker body 只缓存了一部分时的提示, 没有缓存 body 时为空
+// 和缓存的原始字节数比较, 所以 max_buffer_memory 导致的截断也会提示
+func truncatedMarker(size int, buf *bytes.Buffer) string {
+	if buf.Len() == 0 || size <= buf.Len() {
+		return ""
+	}
+	return fmt.Sprintf("...[truncated, total %d bytes]", size)
+}
+
+// markTruncated json 格式下把提示拼到 body 后面, 这时 body 只能作为字符串
+func markTruncated(body interface{}, marker string) interface{} {
+	if marker == "" {
+		return body
+	}
+	switch v := body.(type) {
+	case json.RawMessage:
+		return string(v) + marker
+	case string:
+		return v + marker
+	}
+	return body
+}
+
+// base64Body 非文本的 body 是否用 base64 记录
+func (p *proxyWriter) base64Body(buf *bytes.Buffer) bool {
+	if p.z.BinaryEncoding != BinaryEncodingBase64 || buf.Len() == 0 {
+		return false
+	}
+	_, ok := textBytes(buf.Bytes())
+	return !ok
+}
+
+// bodyEncoding json 格式下 body 的编码, 文本为空
+func (p *proxyWriter) bodyEncoding(buf *bytes.Buffer) string {
+	if p.base64Body(buf) {
+		return BinaryEncodingBase64
+	}
+	return ""
+}
+
+// jsonLine json 格式下的一行日志
+type jsonLine struct {
+	Ts              string            `json:"ts"`
+	Level           string            `json:"level"`
+	ClientIP        string            `json:"client_ip"`
+	DurationMs      float64           `json:"duration_ms"`
+	RequestReadMs   float64           `json:"request_read_ms"`
+	UpstreamMs      float64           `json:"upstream_ms"`
+	ResponseWriteMs float64           `json:"response_write_ms"`
+	Status          int               `json:"status"`
+	Method          string            `json:"method"`
+	Path            string            `json:"path"`
+	Scheme          string            `json:"scheme"`
+	Host            string            `json:"host"`
+	Proto           string            `json:"proto"`
+	ReqContentType  string            `json:"req_content_type"`
+	ReqHeaders      map[string]string `json:"req_headers,omitempty"`
+	Cookies         map[string]string `json:"cookies,omitempty"`
+	ReqSize         int               `json:"req_size"`
+	// ReqSizeDeclared 下游没有读请求体, req_size 取自 Content-Length
+	ReqSizeDeclared bool `json:"req_size_declared,omitempty"`
+	// ReqBodyPartial 下游只读了一部分请求体, req_content_length 是声明的大小
+	ReqBodyPartial   bool              `json:"req_body_partial,omitempty"`
+	ReqContentLength int64             `json:"req_content_length,omitempty"`
+	ReqBody          interface{}       `json:"req_body"`
+	ReqBodyEncoding  string            `json:"req_body_encoding,omitempty"`
+	ReqHash          string            `json:"req_hash,omitempty"`
+	RespContentType  string            `json:"resp_content_type"`
+	RespHeaders      map[string]string `json:"resp_headers,omitempty"`
+	RespTrailers     map[string]string `json:"resp_trailers,omitempty"`
+	RespSize         int               `json:"resp_size"`
+	RespBody         interface{}       `json:"resp_body,omitempty"`
+	RespBodyEncoding string            `json:"resp_body_encoding,omitempty"`
+	RespHash         string            `json:"resp_hash,omitempty"`
+	Upgrade          string            `json:"upgrade,omitempty"`
+	// ClientDisconnected 客户端中途断开, body 可能不完整
+	ClientDisconnected bool              `json:"client_disconnected,omitempty"`
+	Slow               bool              `json:"slow,omitempty"`
+	Route              string            `json:"route,omitempty"`
+	TraceID            string            `json:"trace_id,omitempty"`
+	SpanID             string            `json:"span_id,omitempty"`
+	RequestUUID        string            `json:"request_uuid,omitempty"`
+	GraphQLOperation   string            `json:"graphql_operation,omitempty"`
+	GraphQLQuery       string            `json:"graphql_query,omitempty"`
+	TLSVersion         string            `json:"tls_version,omitempty"`
+	TLSCipher          string            `json:"tls_cipher,omitempty"`
+	TLSServerName      string            `json:"tls_server_name,omitempty"`
+	User               string            `json:"user,omitempty"`
+	UserAgent          string            `json:"user_agent,omitempty"`
+	Referer            string            `json:"referer,omitempty"`
+	Upstream           string            `json:"upstream,omitempty"`
+	CacheStatus        string            `json:"cache_status,omitempty"`
+	Labels             map[string]string `json:"labels,omitempty"`
+	Extra              map[string]string `json:"extra,omitempty"`
+}
+
+// path 请求路径, 带上 query string
+func (p *proxyWriter) path() string {
+	if p.z.DisableQuery || p.req.URL.RawQuery == "" {
+		return p.req.URL.Path
 	}
-	data, _ := json.Marshal(jsonObj)
-	return string(data)
+	return p.req.URL.Path + "?" + p.req.URL.RawQuery
 }
 
-func (p *proxyWriter) writeLog(d time.Duration, w io.Writer) {
-	now := time.Now().Format("2006-01-02 15:04:05")
-	fmt.Fprintf(w, "%s %s %d %s %s %s", now, d.String(), p.code, p.req.Method, p.req.URL.Path, p.req.Header.Get("Content-Type"))
-	fmt.Fprintf(w, " [request body %s] %s", humanize.Bytes(uint64(p.reqSize)), p.tryToJson(p.reqBuf))
-	fmt.Fprintf(w, " %s [response body %s] %s", p.ResponseWriter.Header().Get("Content-Type"), humanize.Bytes(uint64(p.respSize)), p.tryToJson(p.respBuf))
+// clientIP 客户端 ip, 转发头里有多个地址时取最左边的原始客户端
+func (p *proxyWriter) clientIP() string {
+	if p.z.ClientIPHeader != "" {
+		if v := p.req.Header.Get(p.z.ClientIPHeader); v != "" {
+			if i := strings.IndexByte(v, ','); i >= 0 {
+				v = v[:i]
+			}
+			return strings.TrimSpace(v)
+		}
+	}
+	host, _, err := net.SplitHostPort(p.req.RemoteAddr)
+	if err != nil {
+		return p.req.RemoteAddr
+	}
+	return host
+}
+
+// headerField 一个需要记录的 header
+type headerField struct {
+	name  string
+	value string
+}
+
+// pickHeaders 按配置顺序取出存在的 header, 不存在的忽略
+// http.Header.Values 会规范化 key, 所以配置里的大小写无所谓
+// 需要脱敏的 header 只在拷贝上处理, 不会修改真实的请求/响应头
+func (z *ZLog) pickHeaders(h http.Header, names []string) (out []headerField) {
+	for _, name := range names {
+		values := h.Values(name)
+		if len(values) == 0 {
+			continue
+		}
+		name = http.CanonicalHeaderKey(name)
+		// Set-Cookie 不能用逗号合并, 值里的 Expires 本身就带逗号
+		sep := ", "
+		if name == "Set-Cookie" {
+			sep = " | "
+		}
+		if z.shouldRedact(name) {
+			masked := make([]string, len(values))
+			for i := range values {
+				masked[i] = redactHeader(name, values[i])
+			}
+			values = masked
+		}
+		out = append(out, headerField{
+			name:  name,
+			value: strings.Join(values, sep),
+		})
+	}
+	return
+}
+
+func (z *ZLog) shouldRedact(name string) bool {
+	for _, list := range [][]string{DefaultRedactHeaders, z.RedactHeaders} {
+		for _, r := range list {
+			if strings.EqualFold(r, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// redactHeader 隐藏 header 的值
+// cookie 保留名字, Authorization 和 Proxy-Authorization 保留认证方式, 例如 Bearer ***
+// 其他 header 整个隐藏, 值里的空格不代表前面的部分可以公开
+func redactHeader(name, value string) string {
+	const mask = "***"
+	switch name {
+	case "Cookie":
+		parts := strings.Split(value, ";")
+		for i, part := range parts {
+			k, _, _ := strings.Cut(strings.TrimSpace(part), "=")
+			parts[i] = k + "=" + mask
+		}
+		return strings.Join(parts, "; ")
+	case "Set-Cookie":
+		k, _, _ := strings.Cut(value, "=")
+		return strings.TrimSpace(k) + "=" + mask
+	case "Authorization", "Proxy-Authorization":
+		if scheme, _, ok := strings.Cut(value, " "); ok {
+			return scheme + " " + mask
+		}
+	}
+	return mask
+}
+
+func headersMap(fields []headerField) map[string]string {
+	if len(fields) == 0 {
+		return nil
+	}
+	m := make(map[string]string, len(fields))
+	for _, f := range fields {
+		m[f.name] = f.value
+	}
+	return m
+}
+
+func writeHeaders(w io.Writer, title string, fields []headerField) {
+	if len(fields) == 0 {
+		return
+	}
+	fmt.Fprintf(w, " [%s]", title)
+	for _, f := range fields {
+		fmt.Fprintf(w, " %s=%q", f.name, f.value)
+	}
+}
+
+// userAgent 过长的 User-Agent 截断到 MaxUserAgent
+func (p *proxyWriter) userAgent() string {
+	ua := p.req.UserAgent()
+	if len(ua) > MaxUserAgent {
+		ua = ua[:MaxUserAgent]
+	}
+	return ua
+}
+
+// placeholder 读取 caddy 的占位符, 例如 http.auth.user.id
+func (p *proxyWriter) placeholder(key string) string {
+	repl, ok := p.req.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok {
+		return ""
+	}
+	v, _ := repl.GetString(key)
+	return v
+}
+
+// setPlaceholders 请求结束后把统计结果写回 replacer, 外层的 handler 和 caddy 的日志可以使用
+// {http.zlog.status} {http.zlog.req_size} {http.zlog.resp_size}
+func (p *proxyWriter) setPlaceholders() {
+	repl, ok := p.req.Context().Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+	if !ok {
+		return
+	}
+	repl.Set("http.zlog.status", p.code)
+	repl.Set("http.zlog.req_size", p.reqSize)
+	repl.Set("http.zlog.resp_size", p.respSize)
+}
+
+// upstream reverse_proxy 实际使用的上游地址, 本地处理的请求为空
+func (p *proxyWriter) upstream() string {
+	return p.placeholder("http.reverse_proxy.upstream.hostport")
+}
+
+// requestUUID caddy 的请求 id, 旧版本的 caddy 没有时为空
+func (p *proxyWriter) requestUUID() string {
+	// uuid 变量和占位符同时注册, 没有这个变量时读取占位符会 panic
+	if caddyhttp.GetVar(p.req.Context(), "uuid") == nil {
+		return ""
+	}
+	return p.placeholder("http.request.uuid")
+}
+
+// cacheStatus 缓存模块写在响应头里的命中状态, 没有这个头时为空
+func (p *proxyWriter) cacheStatus() string {
+	name := p.z.CacheStatusHeader
+	if name == "" {
+		name = "Cache-Status"
+	}
+	return strings.Join(p.header().Values(name), ", ")
+}
+
+// user 认证用户名, 不会记录密码
+func (p *proxyWriter) user() string {
+	if id := p.placeholder("http.auth.user.id"); id != "" {
+		return id
+	}
+	user, _, _ := p.req.BasicAuth()
+	return user
+}
+
+// scheme 根据连接是否是 tls 判断 http 或 https
+func (p *proxyWriter) scheme() string {
+	if proto := p.forwardedProto(); proto != "" {
+		return proto
+	}
+	if p.req.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// tlsVersionName 例如 TLS1.3
+func tlsVersionName(v uint16) string {
+	switch v {
+	case tls.VersionTLS10:
+		return "TLS1.0"
+	case tls.VersionTLS11:
+		return "TLS1.1"
+	case tls.VersionTLS12:
+		return "TLS1.2"
+	case tls.VersionTLS13:
+		return "TLS1.3"
+	}
+	return fmt.Sprintf("0x%04X", v)
+}
+
+// durationMs 毫秒数, 方便日志系统做聚合
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// timings 把总耗时拆成 读请求体, 等待下游处理, 写响应 三段
+// 请求体为空或者没有读完时读请求体的时间为 0, 没有写响应时写响应的时间为 0
+func (p *proxyWriter) timings(d time.Duration) (read, upstream, write time.Duration) {
+	end := p.start.Add(d)
+	upstreamStart := p.start
+	if done := p.reqDone.Load(); done != 0 {
+		upstreamStart = time.Unix(0, done)
+		read = upstreamStart.Sub(p.start)
+	}
+	upstreamEnd := end
+	if !p.firstByte.IsZero() {
+		upstreamEnd = p.firstByte
+		write = end.Sub(p.firstByte)
+	}
+	// 请求体可能在发出响应头之后才读完, 例如 100-continue 或者流式处理
+	if upstream = upstreamEnd.Sub(upstreamStart); upstream < 0 {
+		upstream = 0
+	}
+	return
+}
+
+// lineSeparator 每行日志的结尾
+func (z *ZLog) lineSeparator() string {
+	if z.LineSeparator == "" {
+		return "\n"
+	}
+	return z.LineSeparator
+}
+
+// formatTime 按配置的 TimeFormat 格式化时间, 统一使用 UTC
+func (z *ZLog) formatTime(t time.Time) string {
+	t = t.UTC()
+	switch z.TimeFormat {
+	case "", TimeFormatRFC3339:
+		return t.Format(time.RFC3339)
+	case TimeFormatUnix:
+		return strconv.FormatInt(t.Unix(), 10)
+	case TimeFormatUnixMilli:
+		return strconv.FormatInt(t.UnixMilli(), 10)
+	default:
+		return t.Format(z.TimeFormat)
+	}
+}
+
+func (p *proxyWriter) writeJSONLog(e *Entry, w io.Writer) {
+	line := jsonLine{
+		RequestReadMs:      durationMs(e.ReadTime),
+		UpstreamMs:         durationMs(e.UpstreamTime),
+		ResponseWriteMs:    durationMs(e.WriteTime),
+		Ts:                 p.z.formatTime(e.Time),
+		Level:              e.Level,
+		ClientIP:           e.ClientIP,
+		Route:              e.Route,
+		TraceID:            e.TraceID,
+		SpanID:             e.SpanID,
+		RequestUUID:        e.RequestUUID,
+		GraphQLOperation:   e.GraphQLOperation,
+		GraphQLQuery:       e.GraphQLQuery,
+		DurationMs:         durationMs(e.Duration),
+		Status:             e.Status,
+		Method:             e.Method,
+		Path:               e.Path,
+		Scheme:             e.Scheme,
+		Host:               e.Host,
+		Proto:              e.Proto,
+		ReqContentType:     e.ReqContentType,
+		ReqHeaders:         headersMap(p.z.pickHeaders(p.req.Header, p.z.RequestHeaders)),
+		Cookies:            headersMap(p.cookies()),
+		ReqSize:            e.ReqSize,
+		ReqSizeDeclared:    e.ReqSizeDeclared,
+		ReqBodyPartial:     e.ReqBodyPartial,
+		ReqBody:            p.reqBody(p.jsonBody),
+		ReqHash:            hexSum(p.reqHash),
+		RespContentType:    e.RespContentType,
+		RespHeaders:        headersMap(p.z.pickHeaders(p.header(), p.z.ResponseHeaders)),
+		RespTrailers:       headersMap(p.z.trailerFields(e.Trailers)),
+		RespSize:           e.RespSize,
+		Labels:             e.Labels,
+		Extra:              e.Extra,
+		ClientDisconnected: e.ClientDisconnected,
+		Slow:               e.Slow,
+	}
+	if e.ReqBodyPartial {
+		line.ReqContentLength = e.ReqContentLength
+	}
+	if p.z.LogUser {
+		line.User = e.User
+	}
+	if p.z.LogUserAgent {
+		line.UserAgent = e.UserAgent
+	}
+	if p.z.LogReferer {
+		line.Referer = e.Referer
+	}
+	if p.z.LogUpstream {
+		line.Upstream = e.Upstream
+	}
+	if p.z.LogCacheStatus {
+		line.CacheStatus = e.CacheStatus
+	}
+	if p.z.LogTLS && e.TLS != nil {
+		line.TLSVersion = tlsVersionName(e.TLS.Version)
+		line.TLSCipher = tls.CipherSuiteName(e.TLS.CipherSuite)
+		line.TLSServerName = e.TLS.ServerName
+	}
+	if p.reqFrames == nil && !p.isMultipart() {
+		line.ReqBodyEncoding = p.bodyEncoding(p.reqBuf)
+	}
+	if p.upgraded() {
+		line.Upgrade = e.Upgrade
+	} else {
+		line.RespBody = p.respBody(p.jsonBody)
+		if p.respFrames == nil {
+			line.RespBodyEncoding = p.bodyEncoding(p.decodedRespBuf())
+		}
+		line.RespHash = hexSum(p.respHash)
+	}
+	data := marshalJSON(line)
+	if len(p.z.Fields) > 0 {
+		data = p.selectFields(data, e)
+	}
+	w.Write(data)
+	io.WriteString(w, p.z.lineSeparator())
+}
 
-	w.Write([]byte(" \n"))
+func (p *proxyWriter) writeLog(e *Entry, w io.Writer) {
+	if p.z.template != nil {
+		p.writeTemplateLog(e, w)
+		return
+	}
+	switch p.z.Format {
+	case FormatJSON:
+		p.writeJSONLog(e, w)
+		return
+	case FormatCLF, FormatCombined:
+		p.writeCLFLog(e, w)
+		return
+	}
+	now := p.z.formatTime(e.Time)
+	fmt.Fprintf(w, "%s %s %s dur_ms=%.3f %d %s %s %s %s", now, e.ClientIP, e.Duration.String(), durationMs(e.Duration), e.Status, e.Method, e.Path, e.Proto, e.ReqContentType)
+	fmt.Fprintf(w, " scheme=%s host=%s", e.Scheme, e.Host)
+	if e.Route != "" {
+		fmt.Fprintf(w, " route=%s", e.Route)
+	}
+	fmt.Fprintf(w, " request_read_ms=%.3f upstream_ms=%.3f response_write_ms=%.3f", durationMs(e.ReadTime), durationMs(e.UpstreamTime), durationMs(e.WriteTime))
+	if e.TraceID != "" {
+		fmt.Fprintf(w, " trace_id=%s", e.TraceID)
+	}
+	if e.SpanID != "" {
+		fmt.Fprintf(w, " span_id=%s", e.SpanID)
+	}
+	if e.RequestUUID != "" {
+		fmt.Fprintf(w, " request_uuid=%s", e.RequestUUID)
+	}
+	if e.GraphQLOperation != "" {
+		fmt.Fprintf(w, " graphql_operation=%q", e.GraphQLOperation)
+	}
+	if e.GraphQLQuery != "" {
+		fmt.Fprintf(w, " graphql_query=%q", e.GraphQLQuery)
+	}
+	if e.ClientDisconnected {
+		w.Write([]byte(" client_disconnected=true"))
+	}
+	if e.Slow {
+		w.Write([]byte(" slow=true"))
+	}
+	if p.z.LogUser {
+		user := e.User
+		if user == "" {
+			user = "-"
+		}
+		fmt.Fprintf(w, " user=%s", user)
+	}
+	if p.z.LogUserAgent && e.UserAgent != "" {
+		fmt.Fprintf(w, " user_agent=%q", e.UserAgent)
+	}
+	if p.z.LogReferer && e.Referer != "" {
+		fmt.Fprintf(w, " referer=%q", e.Referer)
+	}
+	if p.z.LogUpstream && e.Upstream != "" {
+		fmt.Fprintf(w, " upstream=%s", e.Upstream)
+	}
+	if p.z.LogCacheStatus && e.CacheStatus != "" {
+		fmt.Fprintf(w, " cache_status=%q", e.CacheStatus)
+	}
+	if p.z.LogTLS && e.TLS != nil {
+		fmt.Fprintf(w, " tls=%s cipher=%s", tlsVersionName(e.TLS.Version), tls.CipherSuiteName(e.TLS.CipherSuite))
+		if e.TLS.ServerName != "" {
+			fmt.Fprintf(w, " sni=%s", e.TLS.ServerName)
+		}
+	}
+	if p.reqHash != nil {
+		fmt.Fprintf(w, " req_%s=%s", p.z.BodyHash, hexSum(p.reqHash))
+		if !p.upgraded() {
+			fmt.Fprintf(w, " resp_%s=%s", p.z.BodyHash, hexSum(p.respHash))
+		}
+	}
+	writeHeaders(w, "request headers", p.z.pickHeaders(p.req.Header, p.z.RequestHeaders))
+	writeHeaders(w, "cookies", p.cookies())
+	switch {
+	case e.ReqSizeDeclared:
+		fmt.Fprintf(w, " [request body %s declared, not read]", humanize.Bytes(uint64(e.ReqSize)))
+	case e.ReqBodyPartial:
+		fmt.Fprintf(w, " [request body %s, %s] %s", humanize.Bytes(uint64(e.ReqSize)), e.partialNote(), p.reqBody(p.textBody))
+	default:
+		fmt.Fprintf(w, " [request body %s] %s", humanize.Bytes(uint64(e.ReqSize)), p.reqBody(p.textBody))
+	}
+	// 连接被接管或者切换协议后没有常规意义上的响应体, 只记录通过 ResponseWriter 写出的字节数
+	// 被 Hijack 的连接上直接读写的数据不统计
+	if p.upgraded() {
+		fmt.Fprintf(w, " [connection upgraded %s] %s", e.Upgrade, humanize.Bytes(uint64(e.RespSize)))
+		writeKeyValues(w, e.Labels)
+		writeKeyValues(w, e.Extra)
+		io.WriteString(w, p.z.lineSeparator())
+		return
+	}
+	writeHeaders(w, "response headers", p.z.pickHeaders(p.header(), p.z.ResponseHeaders))
+	fmt.Fprintf(w, " %s [response body %s]", e.RespContentType, humanize.Bytes(uint64(e.RespSize)))
+	// 空的响应体不输出多余的空格
+	if body := fmt.Sprint(p.respBody(p.textBody)); body != "" {
+		io.WriteString(w, " "+body)
+	}
+	writeHeaders(w, "response trailers", p.z.trailerFields(e.Trailers))
+	writeKeyValues(w, e.Labels)
+	writeKeyValues(w, e.Extra)
+	io.WriteString(w, p.z.lineSeparator())
 }
 
 // ServeHTTP 打印日志
-// 格式 = 时间 + Code + 请求方法 + PATH + HOSTNAME + 路径 + 请求体 + 响应体
+// 文本格式 = 时间 + 客户端 ip + 耗时 + Code + 请求方法 + 路径 + 协议 + 请求 Content-Type + scheme + host + 分段耗时
+// + 可选字段 + 请求头 + 请求体 + 响应头 + 响应 Content-Type + 响应体
 func (z *ZLog) ServeHTTP(w http.ResponseWriter, r *http.Request, next caddyhttp.Handler) (err error) {
+	// 链路 id 要在调用下游之前确定, 不记录日志的请求也需要
+	traceID := z.traceID(w, r)
+	otelTrace, spanID := z.otelIDs(r)
+	// 没有配置 trace_header 时使用 traceparent 里的 trace id
+	if traceID == "" {
+		traceID = otelTrace
+	}
+	// 没有任何输出或者不需要记录的请求直接放行, 不包装 body
+	// 模块正在关闭时输出可能已经关掉, 新请求也不再记录
+	if reason := z.passReason(r); reason != "" {
+		z.decision(r, reason)
+		return next.ServeHTTP(w, r)
+	}
+	sampled := z.sampled()
+	if !sampled && !z.SampleAlwaysErrors {
+		z.decision(r, "not sampled")
+		return next.ServeHTTP(w, r)
+	}
 	start := time.Now()
 	writer := proxyWriter{
 		ResponseWriter: w,
+		start:          start,
+		code:           http.StatusOK,
 		req:            r,
+		traceID:        traceID,
+		spanID:         spanID,
 		body:           r.Body,
-		truncate:       int(z.Truncate),
+		reqTruncate:    z.truncateSize(z.TruncateRequest),
+		respTruncate:   z.truncateSize(z.TruncateResponse),
+		z:              z,
+		reqBuf:         getBuffer(),
+		respBuf:        getBuffer(),
+		reqHash:        z.newBodyHash(),
+		respHash:       z.newBodyHash(),
+	}
+	if z.GRPCDecode && isGRPC(r.Header.Get("Content-Type")) {
+		writer.reqFrames = &grpcFrames{}
+	}
+	// 捕获的 body 要等 writeLog 之后才能还回去
+	defer func() {
+		writer.release()
+		putBuffer(writer.reqBuf)
+		putBuffer(writer.respBuf)
+	}()
+	// 小于阈值的 body 要完整缓存, 大的 body 在写日志前整个丢掉
+	if z.FullBodyBelow > 0 {
+		writer.reqTruncate = int(z.FullBodyBelow)
+		writer.respTruncate = int(z.FullBodyBelow)
+	}
+	if size, ok := truncateOverride(r); ok {
+		writer.reqTruncate = size
+		writer.respTruncate = size
+	}
+	if z.AllowTruncateHeader {
+		if size, ok := parseTruncate(r.Header.Get(TruncateHeader)); ok {
+			if size < writer.reqTruncate {
+				writer.reqTruncate = size
+			}
+			if size < writer.respTruncate {
+				writer.respTruncate = size
+			}
+		}
+	}
+	// 没被采样的请求只有出错时才记录, 不缓存 body
+	if !sampled {
+		writer.reqTruncate = 0
+		writer.respTruncate = 0
+	}
+	writer.normalTruncate = writer.respTruncate
+	if errTruncate := int(z.ErrorTruncate); errTruncate > writer.respTruncate {
+		writer.respTruncate = errTruncate
+	}
+	writer.skipReqBody = z.DisableRequestCapture || !z.captureContentType(r.Header.Get("Content-Type"))
+	if z.DisableResponseCapture {
+		writer.respChecked = true
+		writer.skipRespBody = true
+	}
+	if z.CaptureWhen != nil && !z.CaptureWhen.Match(r) {
+		writer.skipReqBody = true
+		writer.respChecked = true
+		writer.skipRespBody = true
+	}
+	hasBody := r.Body != nil && r.Body != http.NoBody
+	if z.BufferHint > 0 {
+		// 没有请求体时不用为 reqBuf 预分配
+		if hasBody && !writer.skipReqBody {
+			growBuffer(writer.reqBuf, z.BufferHint, writer.reqTruncate)
+		}
+		if !writer.skipRespBody {
+			growBuffer(writer.respBuf, z.BufferHint, writer.respTruncate)
+		}
+	}
+	if z.StartLog {
+		writer.writeStartLog()
+	}
+	// 没有请求体时保留 http.NoBody, reverse_proxy 据此判断不需要转发请求体
+	// Expect: 100-continue 时 net/http 在第一次读 body 时才发出 100 Continue
+	// 这里不会提前读取, 只有下游真正读 body 时才会透传到底层的 Read, 握手不受影响
+	if hasBody {
+		r.Body = requestBody{&writer}
 	}
-	r.Body = &writer
 
+	// 每个请求只会在 next.ServeHTTP 返回之后写一行日志
+	// 分块传输和 Flush 只会把数据下发给客户端, 不会触发写日志, 此时 respSize 是完整的大小, respBuf 最多 respTruncate 字节
+	// 被 Hijack 的连接 (例如 websocket) 要等下游处理完这个连接, next.ServeHTTP 返回后才会写日志
 	err = next.ServeHTTP(&writer, r)
 	end := time.Now()
-	if z.LogFile != nil {
-		var buf bytes.Buffer
-		writer.writeLog(end.Sub(start), &buf)
-		s := buf.String()
-		z.LogFile.Write([]byte(s))
-		os.Stdout.Write([]byte(s))
+	writer.stopRead()
+	// 下游返回错误但没有写响应时, 错误页由外层的 handler 写出, 状态码从错误里取
+	if err != nil && !writer.wroteHeader {
+		writer.code = errorStatus(err)
+	}
+	// 客户端中途断开时仍然记录已经缓存的部分
+	writer.clientDisconnected = r.Context().Err() != nil
+	writer.setPlaceholders()
+	if writer.skipped() {
+		z.decision(r, "skipped by zlog_skip")
+		return
+	}
+	if !sampled && isSuccess(writer.code) {
+		z.decision(r, "not sampled")
+		return
+	}
+	if !z.matchStatus(writer.code) {
+		z.decision(r, "status not matched")
+	} else {
+		writer.trimErrorBody()
+		writer.dropLargeBodies()
+		entry := writer.entry(end)
+		// graphql 字段在 entry 里已经从 reqBuf 取出, 这里只丢掉要记录的请求体
+		if z.RequestBodyOnError && isSuccess(writer.code) {
+			writer.reqBuf.Reset()
+			entry.ReqBody = nil
+		}
+		if !z.runHooks(entry) {
+			z.decision(r, "dropped by hook")
+			return
+		}
+		writer.applyEntry(entry)
+		// 限速放在格式化之前, 被丢弃的日志不需要序列化
+		if z.limiter != nil && !z.limiter.Allow() {
+			z.rateLimited.Add(1)
+			z.countDropped(1)
+			z.decision(r, "rate limited")
+			return
+		}
+		buf := getBuffer()
+		writer.writeLog(entry, buf)
+		z.capLine(buf)
+		z.decision(r, "logged")
+		line := logLine{buf: buf, status: writer.code, slow: entry.Slow}
+		if z.Dedup {
+			z.writeDedup(writer.dedupKey(), line)
+		} else {
+			z.write(line)
+		}
 	}
 	return
 }
 
+// errorStatus caddyhttp.HandlerError 的状态码, 其他错误按 500 处理
+func errorStatus(err error) int {
+	var handlerErr caddyhttp.HandlerError
+	if errors.As(err, &handlerErr) && handlerErr.StatusCode != 0 {
+		return handlerErr.StatusCode
+	}
+	return http.StatusInternalServerError
+}
+
+// trimErrorBody 不是 5xx 的响应裁剪回正常的截断大小
+func (p *proxyWriter) trimErrorBody() {
+	if p.respTruncate <= p.normalTruncate || p.code >= http.StatusInternalServerError {
+		return
+	}
+	p.respTruncate = p.normalTruncate
+	if p.respBuf.Len() > p.respTruncate {
+		p.respBuf.Truncate(p.respTruncate)
+	}
+}
+
+// dropLargeBodies 开启 full_body_below 时, 大小超过阈值的 body 不记录内容
+func (p *proxyWriter) dropLargeBodies() {
+	limit := int(p.z.FullBodyBelow)
+	if limit == 0 {
+		return
+	}
+	if p.reqSize >= limit {
+		p.reqBuf.Reset()
+	}
+	if p.respSize >= limit {
+		p.respBuf.Reset()
+	}
+}
+
+// skipped 下游标记了不需要记录
+func (p *proxyWriter) skipped() bool {
+	switch v := caddyhttp.GetVar(p.req.Context(), SkipVar).(type) {
+	case bool:
+		if v {
+			return true
+		}
+	case string:
+		if on, _ := strconv.ParseBool(v); on {
+			return true
+		}
+	}
+	on, _ := strconv.ParseBool(p.header().Get(SkipHeader))
+	return on
+}
+
+// stopping 模块的 context 已经取消
+func (z *ZLog) stopping() bool {
+	return z.ctx != nil && z.ctx.Err() != nil
+}
+
+// truncateOverride 读取请求上覆盖的截断大小, 非法或者超过 MaxTruncateOverride 时忽略
+func truncateOverride(r *http.Request) (int, bool) {
+	var v string
+	switch val := caddyhttp.GetVar(r.Context(), TruncateVar).(type) {
+	case string:
+		v = val
+	case int:
+		v = strconv.Itoa(val)
+	}
+	return parseTruncate(v)
+}
+
+// parseTruncate 解析覆盖的截断大小, 空值 非法或者超过 MaxTruncateOverride 时返回 false
+func parseTruncate(v string) (int, bool) {
+	if v == "" {
+		return 0, false
+	}
+	size, err := humanize.ParseBytes(v)
+	if err != nil || size == 0 || size > MaxTruncateOverride {
+		return 0, false
+	}
+	return int(size), true
+}
+
+// writeStartLog 请求开始时的日志, 只有请求行和 trace_id
+// 没有配置 trace_header 时生成一个只用于日志的 id, 不会写到请求头和响应头
+func (p *proxyWriter) writeStartLog() {
+	if p.z.Format == FormatCLF || p.z.Format == FormatCombined {
+		return
+	}
+	if p.traceID == "" {
+		p.traceID = randomHex(8)
+	}
+	// 开始日志同样受 rate_limit 限制, 被丢弃的不需要序列化
+	if p.z.limiter != nil && !p.z.limiter.Allow() {
+		p.z.rateLimited.Add(1)
+		p.z.countDropped(1)
+		return
+	}
+	buf := getBuffer()
+	if p.z.Format == FormatJSON {
+		buf.Write(marshalJSON(startLine{
+			Ts:       p.z.formatTime(p.start),
+			Event:    "started",
+			ClientIP: p.clientIP(),
+			Method:   p.req.Method,
+			Path:     p.path(),
+			Host:     p.req.Host,
+			Proto:    p.req.Proto,
+			TraceID:  p.traceID,
+		}))
+	} else {
+		fmt.Fprintf(buf, "%s %s started %s %s %s host=%s trace_id=%s", p.z.formatTime(p.start), p.clientIP(), p.req.Method, p.path(), p.req.Proto, p.req.Host, p.traceID)
+	}
+	buf.WriteString(p.z.lineSeparator())
+	p.z.capLine(buf)
+	p.z.write(logLine{buf: buf, start: true})
+}
+
+// startLine json 格式下请求开始时的日志
+type startLine struct {
+	Ts       string `json:"ts"`
+	Event    string `json:"event"`
+	ClientIP string `json:"client_ip"`
+	Method   string `json:"method"`
+	Path     string `json:"path"`
+	Host     string `json:"host"`
+	Proto    string `json:"proto"`
+	TraceID  string `json:"trace_id"`
+}
+
+// truncateSize 单独配置的截断大小, 没有配置时使用 Truncate
+func (z *ZLog) truncateSize(size uint64) int {
+	if size > 0 {
+		return int(size)
+	}
+	return int(z.Truncate)
+}
+
+// maxTruncate 可能用到的最大截断大小, buffer_hint 超过它没有意义
+func (z *ZLog) maxTruncate() uint64 {
+	limit := z.Truncate
+	for _, size := range []uint64{z.TruncateRequest, z.TruncateResponse, z.FullBodyBelow, z.ErrorTruncate} {
+		if size > limit {
+			limit = size
+		}
+	}
+	return limit
+}
+
+// hasOutput 是否配置了任何日志输出
+func (z *ZLog) hasOutput() bool {
+	z.outputMu.Lock()
+	defer z.outputMu.Unlock()
+	return len(z.sinks) > 0
+}
+
+// output 同一行日志分发给所有的输出
+// 同步模式下多个请求会同时调用, 加锁保证每行日志完整写入, 不会和其他行交错
+func (z *ZLog) output(line logLine) {
+	data := line.buf.Bytes()
+	if z.aead != nil {
+		data = z.encryptLine(data)
+	}
+	z.outputMu.Lock()
+	defer z.outputMu.Unlock()
+	// Cleanup 之后文件已经关闭, 例如 Shutdown 之后还没结束的 websocket
+	if z.closed {
+		z.countDropped(1)
+		return
+	}
+	healthy := true
+	for _, s := range z.sinks {
+		if ss, ok := s.(statusSink); ok && line.start {
+			// 开始日志不知道最终的状态码, 跳过 file_2xx 这样的分类文件, 默认文件不按分类排除
+			if ss.class != 0 {
+				continue
+			}
+			s = ss.Sink
+		}
+		if err := s.Write(data, line.status); err != nil {
+			healthy = false
+			z.lastError.Store(err.Error())
+			z.countError()
+		}
+	}
+	if line.slow {
+		if err := z.writeSlow(data, line.status); err != nil {
+			healthy = false
+			z.lastError.Store(err.Error())
+			z.countError()
+		}
+	}
+	z.unhealthy.Store(!healthy)
+	z.countWritten(len(data))
+}
+
+// checkWritableDir 目录存在并且可以创建文件
+func checkWritableDir(dir string) error {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", dir)
+	}
+	f, err := os.CreateTemp(dir, ".zlog-*")
+	if err != nil {
+		return fmt.Errorf("directory %s is not writable: %v", dir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
 // parseCaddyfile unmarshals tokens from h into a new Middleware.
 func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
 	var zlog ZLog
@@ -242,25 +2275,173 @@ func parseCaddyfile(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error)
 
 // Provision implements caddy.Provisioner.
 func (z *ZLog) Provision(ctx caddy.Context) error {
-	z.LogFile, _ = z.FileWriter.OpenWriter()
+	z.logger = ctx.Logger()
+	z.ctx = ctx
+	// 通过 json 配置时不会经过 UnmarshalCaddyfile, 默认值在这里补上
+	if z.Truncate == 0 {
+		z.Truncate = DefaultTruncate
+	}
+	if z.FileWriter.Filename == "" {
+		z.FileWriter.Filename = z.FileName
+	}
+	if z.Metrics {
+		initMetrics()
+	}
+	if z.FormatTemplate != "" {
+		var err error
+		if z.template, err = parseTemplate(z.FormatTemplate, z.FormatTemplateStrict); err != nil {
+			return fmt.Errorf("parsing format_template: %v", err)
+		}
+	}
+	var err error
+	if z.aead, err = z.loadEncryptKey(); err != nil {
+		return err
+	}
+	z.provisionLabels()
+	if err := z.provisionTrustedProxies(); err != nil {
+		return err
+	}
+	if err := z.provisionFields(); err != nil {
+		return err
+	}
+	if z.CaptureWhen != nil {
+		if err := z.CaptureWhen.Provision(ctx); err != nil {
+			return fmt.Errorf("parsing capture_when: %v", err)
+		}
+	}
+	for _, pattern := range z.RedactPatterns {
+		re, err := compileRedactPattern(pattern)
+		if err != nil {
+			return fmt.Errorf("parsing redact_pattern %s: %v", pattern, err)
+		}
+		z.redactRegex = append(z.redactRegex, re)
+	}
+	if z.RateLimit > 0 {
+		// 允许一秒内的突发, 流量平稳时不会丢
+		z.limiter = rate.NewLimiter(rate.Limit(z.RateLimit), z.RateLimit)
+	}
+	if err := z.provisionSinks(); err != nil {
+		return err
+	}
+	if z.SlowFile != nil {
+		if err := z.provisionSlowFile(); err != nil {
+			return err
+		}
+	}
+	if z.RollInterval > 0 {
+		if err := z.startRoll(); err != nil {
+			return err
+		}
+	}
+	if z.ReopenOnSignal {
+		if err := z.startReopen(); err != nil {
+			return err
+		}
+	}
+	if z.Async {
+		z.startAsync()
+	}
+	registerInstance(z)
 	return nil
 }
 
 // Validate implements caddy.Validator.
 func (z *ZLog) Validate() error {
+	if z.FileWriter.Filename != "" {
+		if err := checkWritableDir(filepath.Dir(z.FileWriter.Filename)); err != nil {
+			return fmt.Errorf("invalid file_name %s: %v", z.FileWriter.Filename, err)
+		}
+	}
+	for class, sfw := range z.StatusFiles {
+		if _, err := parseStatusClass(class); err != nil {
+			return err
+		}
+		if err := checkWritableDir(filepath.Dir(sfw.Filename)); err != nil {
+			return fmt.Errorf("invalid file_%s %s: %v", class, sfw.Filename, err)
+		}
+	}
+	if z.SlowFile != nil {
+		if err := checkWritableDir(filepath.Dir(z.SlowFile.Filename)); err != nil {
+			return fmt.Errorf("invalid slow_file %s: %v", z.SlowFile.Filename, err)
+		}
+	}
+	if _, ok := bodyHashes[z.BodyHash]; z.BodyHash != "" && !ok {
+		return fmt.Errorf("unknown body_hash: %s", z.BodyHash)
+	}
+	if z.EncryptKeyFile != "" && z.EncryptKeyEnv != "" {
+		return fmt.Errorf("encrypt key can only come from one of file or env")
+	}
+	if limit := z.maxTruncate(); z.BufferHint > limit {
+		return fmt.Errorf("buffer_hint %s exceeds the largest truncate size %s", humanize.Bytes(z.BufferHint), humanize.Bytes(limit))
+	}
+	if err := validateLevelMap(z.LevelMap); err != nil {
+		return err
+	}
+	if z.RateLimit < 0 {
+		return fmt.Errorf("rate_limit must be positive: %d", z.RateLimit)
+	}
+	if z.TimeFormat != "" && z.formatTime(time.Unix(0, 0)) == "" {
+		return fmt.Errorf("invalid time_format: %q", z.TimeFormat)
+	}
+	// 下面这些 Caddyfile 解析时已经检查过, json 配置不经过 UnmarshalCaddyfile, 这里再检查一次
+	switch z.Format {
+	case "", FormatText, FormatJSON, FormatCLF, FormatCombined:
+	default:
+		return fmt.Errorf("unknown format: %s", z.Format)
+	}
+	if z.BinaryEncoding != "" && z.BinaryEncoding != BinaryEncodingBase64 {
+		return fmt.Errorf("unknown binary_encoding: %s", z.BinaryEncoding)
+	}
+	switch z.SyslogNetwork {
+	case "", "udp", "tcp", "unix":
+	default:
+		return fmt.Errorf("unknown syslog_network: %s", z.SyslogNetwork)
+	}
+	if _, ok := syslogFacilities[z.SyslogFacility]; z.SyslogFacility != "" && !ok {
+		return fmt.Errorf("unknown syslog_facility: %s", z.SyslogFacility)
+	}
+	if z.Sample < 0 || z.Sample > 1 {
+		return fmt.Errorf("sample must be a fraction in (0, 1]: %v", z.Sample)
+	}
+	for _, sr := range z.Status {
+		if !sr.valid() {
+			return fmt.Errorf("invalid status range: %d-%d", sr.Min, sr.Max)
+		}
+	}
+	z.logConfig()
 	return nil
 }
 
 func (z *ZLog) Cleanup() error {
-	if z.LogFile != nil {
-		z.LogFile.Close()
+	unregisterInstance(z)
+	z.stopRoll()
+	z.stopReopen()
+	z.flushPending()
+	z.stopAsync()
+	// 还在处理的请求可能同时在写, 在 outputMu 下摘掉所有输出再关闭
+	z.outputMu.Lock()
+	sinks, slow := z.sinks, z.slowSink
+	z.sinks, z.slowSink = nil, nil
+	z.closed = true
+	z.outputMu.Unlock()
+	for _, s := range sinks {
+		s.Close()
+	}
+	if slow != nil {
+		slow.Close()
 	}
 	return nil
 }
 
 var (
+	_ caddy.AdminRouter           = adminAPI{}
 	_ caddy.Provisioner           = (*ZLog)(nil)
 	_ caddy.Validator             = (*ZLog)(nil)
+	_ caddy.CleanerUpper          = (*ZLog)(nil)
 	_ caddyhttp.MiddlewareHandler = (*ZLog)(nil)
 	_ caddyfile.Unmarshaler       = (*ZLog)(nil)
+	_ http.Flusher                = (*proxyWriter)(nil)
+	_ http.Hijacker               = (*proxyWriter)(nil)
+	_ io.ReaderFrom               = (*proxyWriter)(nil)
+	_ io.ReadCloser               = requestBody{}
 )
@@ -0,0 +1,172 @@
+package zlog
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// testSink 收集写出的日志和对应的状态码
+type testSink struct {
+	mu       sync.Mutex
+	buf      bytes.Buffer
+	statuses []int
+}
+
+func (s *testSink) Write(line []byte, status int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.buf.Write(line)
+	s.statuses = append(s.statuses, status)
+	return nil
+}
+
+func (s *testSink) Close() error { return nil }
+
+func (s *testSink) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.buf.String()
+}
+
+// lines 按换行拆开, 去掉最后的空行
+func (s *testSink) lines() []string {
+	out := strings.TrimSuffix(s.String(), "\n")
+	if out == "" {
+		return nil
+	}
+	return strings.Split(out, "\n")
+}
+
+// provisionTest 不写标准输出, 日志写到返回的 testSink
+func provisionTest(t testing.TB, z *ZLog) *testSink {
+	t.Helper()
+	z.DisableStdout = true
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	if err := z.Provision(ctx); err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	if err := z.Validate(); err != nil {
+		cancel()
+		t.Fatal(err)
+	}
+	sink := &testSink{}
+	z.outputMu.Lock()
+	z.sinks = append(z.sinks, sink)
+	z.outputMu.Unlock()
+	t.Cleanup(func() {
+		z.Cleanup()
+		cancel()
+	})
+	return sink
+}
+
+// newTestRequest 和 caddy 一样在 context 里放上 replacer 和 vars
+func newTestRequest(method, target string, body io.Reader) *http.Request {
+	return withTestContext(httptest.NewRequest(method, target, body))
+}
+
+// withTestContext 给真实 server 收到的请求加上 replacer 和 vars
+func withTestContext(r *http.Request) *http.Request {
+	repl := caddy.NewReplacer()
+	ctx := context.WithValue(r.Context(), caddy.ReplacerCtxKey, repl)
+	ctx = context.WithValue(ctx, caddyhttp.VarsCtxKey, map[string]any{})
+	return r.WithContext(ctx)
+}
+
+func serveTest(z *ZLog, w http.ResponseWriter, r *http.Request, h caddyhttp.HandlerFunc) error {
+	return z.ServeHTTP(w, r, h)
+}
+
+func TestServeHTTPHandlerErrorStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status string
+	}{
+		{"handler error", caddyhttp.Error(http.StatusBadGateway, io.ErrUnexpectedEOF), " 502 "},
+		{"plain error", io.ErrUnexpectedEOF, " 500 "},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			z := &ZLog{Status: []StatusRange{{Min: 500, Max: 599}}}
+			sink := provisionTest(t, z)
+			err := serveTest(z, httptest.NewRecorder(), newTestRequest("GET", "/", nil), func(w http.ResponseWriter, r *http.Request) error {
+				return tt.err
+			})
+			if err != tt.err {
+				t.Fatalf("error not passed through: %v", err)
+			}
+			lines := sink.lines()
+			if len(lines) != 1 || !strings.Contains(lines[0], tt.status) {
+				t.Fatalf("want one entry with status%s, got %q", tt.status, lines)
+			}
+		})
+	}
+}
+
+// decodeEntry 解析一行 json 格式的日志
+func decodeEntry(t testing.TB, line string) map[string]any {
+	t.Helper()
+	var m map[string]any
+	if err := json.Unmarshal([]byte(line), &m); err != nil {
+		t.Fatalf("decode %q: %v", line, err)
+	}
+	return m
+}
+
+func TestTruncateOverride(t *testing.T) {
+	tests := []struct {
+		name   string
+		allow  bool
+		header string
+		v      any
+		want   int
+	}{
+		{"header ignored by default", false, "2", nil, 8},
+		{"header lowers when allowed", true, "2", nil, 2},
+		{"header cannot raise", true, "1KB", nil, 8},
+		{"invalid header", true, "abc", nil, 8},
+		{"vars raises", false, "", "32", 32},
+		{"vars int", false, "", 16, 16},
+		{"header lowers vars", true, "4", "32", 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			z := &ZLog{Truncate: 8, Format: FormatJSON, AllowTruncateHeader: tt.allow}
+			sink := provisionTest(t, z)
+			r := newTestRequest("GET", "/", nil)
+			if tt.header != "" {
+				r.Header.Set(TruncateHeader, tt.header)
+			}
+			if tt.v != nil {
+				caddyhttp.SetVar(r.Context(), TruncateVar, tt.v)
+			}
+			err := serveTest(z, httptest.NewRecorder(), r, func(w http.ResponseWriter, r *http.Request) error {
+				_, err := io.WriteString(w, strings.Repeat("a", 64))
+				return err
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := sink.lines()
+			if len(lines) != 1 {
+				t.Fatalf("want one entry, got %q", lines)
+			}
+			body, _ := decodeEntry(t, lines[0])["resp_body"].(string)
+			if !strings.HasPrefix(body, strings.Repeat("a", tt.want)+"...") {
+				t.Fatalf("want resp_body of %d bytes, got %q", tt.want, body)
+			}
+		})
+	}
+}
@@ -0,0 +1,51 @@
+package zlog
+
+import (
+	"bytes"
+	"fmt"
+	"unicode/utf8"
+)
+
+// capLine 整行超过 MaxLine 时截掉尾部, 在 body 截断之后兜底, 避免超过下游的单行长度限制
+// 文本格式直接截断并追加标记, json 格式截断后的内容放到 line 字段里, 保证仍然是合法的 json
+func (z *ZLog) capLine(buf *bytes.Buffer) {
+	sep := z.lineSeparator()
+	n := len(bytes.TrimSuffix(buf.Bytes(), []byte(sep)))
+	if z.MaxLine == 0 || uint64(n) <= z.MaxLine {
+		return
+	}
+	var marker string
+	if z.template == nil && z.Format == FormatJSON {
+		marker = fmt.Sprintf(`{"line_truncated":true,"line_size":%d,"line":`, n)
+	} else {
+		marker = fmt.Sprintf("...[line truncated, total %d bytes]", n)
+	}
+	keep := runeStart(buf.Bytes(), int(z.MaxLine)-len(marker))
+	if z.template == nil && z.Format == FormatJSON {
+		// 转义之后会变长, 超出多少再少保留多少
+		line := marshalJSON(string(buf.Bytes()[:keep]))
+		for over := len(marker) + len(line) + 1 - int(z.MaxLine); over > 0 && keep > 0; over = len(marker) + len(line) + 1 - int(z.MaxLine) {
+			keep = runeStart(buf.Bytes(), keep-over)
+			line = marshalJSON(string(buf.Bytes()[:keep]))
+		}
+		buf.Reset()
+		buf.WriteString(marker)
+		buf.Write(line)
+		buf.WriteByte('}')
+	} else {
+		buf.Truncate(keep)
+		buf.WriteString(marker)
+	}
+	buf.WriteString(sep)
+}
+
+// runeStart 截断的位置往前退到字符的边界, 不切断多字节字符
+func runeStart(b []byte, i int) int {
+	if i <= 0 {
+		return 0
+	}
+	for i > 0 && !utf8.RuneStart(b[i]) {
+		i--
+	}
+	return i
+}
@@ -0,0 +1,76 @@
+package zlog
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// zlogMetrics 和 caddyhttp 一样注册到 prometheus 默认的 registry, 由 caddy 的 metrics 接口暴露
+// 重新加载配置时不能重复注册, 所以是全局的
+var zlogMetrics = struct {
+	init    sync.Once
+	written prometheus.Counter
+	dropped prometheus.Counter
+	errors  prometheus.Counter
+	bytes   prometheus.Counter
+}{}
+
+func initMetrics() {
+	zlogMetrics.init.Do(func() {
+		const ns, sub = "caddy", "zlog"
+		zlogMetrics.written = promauto.NewCounter(prometheus.CounterOpts{
+			Namespace: ns,
+			Subsystem: sub,
+			Name:      "entries_written_total",
+			Help:      "Number of log entries written.",
+		})
+		zlogMetrics.dropped = promauto.NewCounter(prometheus.CounterOpts{
+			Namespace: ns,
+			Subsystem: sub,
+			Name:      "entries_dropped_total",
+			Help:      "Number of log entries dropped because a queue was full or a sink failed.",
+		})
+		zlogMetrics.errors = promauto.NewCounter(prometheus.CounterOpts{
+			Namespace: ns,
+			Subsystem: sub,
+			Name:      "write_errors_total",
+			Help:      "Number of failed log writes.",
+		})
+		zlogMetrics.bytes = promauto.NewCounter(prometheus.CounterOpts{
+			Namespace: ns,
+			Subsystem: sub,
+			Name:      "bytes_written_total",
+			Help:      "Number of bytes of log entries written.",
+		})
+	})
+}
+
+// 开启 metrics 时 Provision 里已经调用过 initMetrics
+// 实例自己的计数总是更新, 给 admin 接口使用
+func (z *ZLog) countWritten(n int) {
+	z.written.Add(1)
+	z.writtenBytes.Add(uint64(n))
+	if !z.Metrics {
+		return
+	}
+	zlogMetrics.written.Inc()
+	zlogMetrics.bytes.Add(float64(n))
+}
+
+func (z *ZLog) countDropped(n int) {
+	z.dropped.Add(uint64(n))
+	if !z.Metrics {
+		return
+	}
+	zlogMetrics.dropped.Add(float64(n))
+}
+
+func (z *ZLog) countError() {
+	z.writeErrors.Add(1)
+	if !z.Metrics {
+		return
+	}
+	zlogMetrics.errors.Inc()
+}
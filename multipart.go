@@ -0,0 +1,60 @@
+package zlog
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+// reqBody multipart 请求输出各个字段的摘要, gRPC 请求输出帧信息, 其余交给 render
+func (p *proxyWriter) reqBody(render func(*bytes.Buffer) interface{}) interface{} {
+	if p.reqFrames != nil {
+		return p.reqFrames.String()
+	}
+	if summary, ok := p.multipartSummary(); ok {
+		return summary
+	}
+	return markTruncated(render(p.reqBuf), truncatedMarker(p.reqSize, p.reqBuf))
+}
+
+// isMultipart 请求是 multipart/form-data
+func (p *proxyWriter) isMultipart() bool {
+	mediaType, params, err := mime.ParseMediaType(p.req.Header.Get("Content-Type"))
+	return err == nil && mediaType == "multipart/form-data" && params["boundary"] != ""
+}
+
+// multipartSummary 文本字段输出名字和值, 文件只输出字段名, 文件名和大小
+// 只解析已经缓存的 reqBuf, 不会读取真正的请求体, 摘要的长度同样受 truncate 限制
+func (p *proxyWriter) multipartSummary() (string, bool) {
+	if !p.isMultipart() {
+		return "", false
+	}
+	_, params, _ := mime.ParseMediaType(p.req.Header.Get("Content-Type"))
+	mr := multipart.NewReader(bytes.NewReader(p.reqBuf.Bytes()), params["boundary"])
+	var parts []string
+	for {
+		// reqBuf 可能被截断, 读到错误就停下
+		part, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		var value bytes.Buffer
+		size, _ := io.Copy(&value, part)
+		if part.FileName() != "" {
+			parts = append(parts, fmt.Sprintf("%s=<file %s %s>", part.FormName(), part.FileName(), humanize.Bytes(uint64(size))))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s=%q", part.FormName(), value.String()))
+		}
+		part.Close()
+	}
+	summary := strings.Join(parts, "; ")
+	if len(summary) > p.reqTruncate {
+		summary = summary[:p.reqTruncate]
+	}
+	return p.z.maskPatterns(summary), true
+}
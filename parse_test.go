@@ -0,0 +1,141 @@
+package zlog
+
+import (
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func TestParseOnOff(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    bool
+		wantErr bool
+	}{
+		{"log_tls on", true, false},
+		{"log_tls off", false, false},
+		{"log_tls yes", false, true},
+		{"log_tls", false, true},
+		{"log_tls on off", false, true},
+	}
+	for _, tt := range tests {
+		d := caddyfile.NewTestDispenser(tt.input)
+		d.Next()
+		got, err := parseOnOff(d)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseOnOff(%q) = %v, %v", tt.input, got, err)
+		}
+	}
+}
+
+func TestParseStatusRange(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    StatusRange
+		wantErr bool
+	}{
+		{"404", StatusRange{404, 404}, false},
+		{"500-599", StatusRange{500, 599}, false},
+		{"599-500", StatusRange{}, true},
+		{"99", StatusRange{}, true},
+		{"500-1000", StatusRange{}, true},
+		{"5xx", StatusRange{}, true},
+		{"500-", StatusRange{}, true},
+	}
+	for _, tt := range tests {
+		got, err := parseStatusRange(tt.input)
+		if (err != nil) != tt.wantErr || (!tt.wantErr && got != tt.want) {
+			t.Errorf("parseStatusRange(%q) = %v, %v", tt.input, got, err)
+		}
+	}
+}
+
+func TestParseStatusClass(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int
+		wantErr bool
+	}{
+		{"2xx", 2, false},
+		{"5xx", 5, false},
+		{"6xx", 0, true},
+		{"0xx", 0, true},
+		{"2XX", 0, true},
+		{"20x", 0, true},
+		{"2xxx", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseStatusClass(tt.input)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseStatusClass(%q) = %v, %v", tt.input, got, err)
+		}
+	}
+}
+
+func TestParseLabel(t *testing.T) {
+	tests := []struct {
+		input      string
+		key, value string
+		ok         bool
+	}{
+		{"env=prod", "env", "prod", true},
+		{"empty=", "empty", "", true},
+		{"url=a=b", "url", "a=b", true},
+		{"=prod", "", "prod", false},
+		{"env", "env", "", false},
+	}
+	for _, tt := range tests {
+		key, value, ok := parseLabel(tt.input)
+		if key != tt.key || value != tt.value || ok != tt.ok {
+			t.Errorf("parseLabel(%q) = %q, %q, %v", tt.input, key, value, ok)
+		}
+	}
+}
+
+func TestParseTraceparent(t *testing.T) {
+	const traceID, spanID = "4bf92f3577b34da6a3ce929d0e0e4736", "00f067aa0ba902b7"
+	tests := []struct {
+		input string
+		ok    bool
+	}{
+		{"00-" + traceID + "-" + spanID + "-01", true},
+		{" 00-" + traceID + "-" + spanID + "-00 ", true},
+		// 以后的版本可能在后面追加字段
+		{"01-" + traceID + "-" + spanID + "-01-extra", true},
+		{"ff-" + traceID + "-" + spanID + "-01", false},
+		{"00-00000000000000000000000000000000-" + spanID + "-01", false},
+		{"00-" + traceID + "-0000000000000000-01", false},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-" + spanID + "-01", false},
+		{"00-" + traceID + "-" + spanID, false},
+		{"00-" + traceID[:30] + "zz-" + spanID + "-01", false},
+	}
+	for _, tt := range tests {
+		gotTrace, gotSpan, ok := parseTraceparent(tt.input)
+		if ok != tt.ok || (ok && (gotTrace != traceID || gotSpan != spanID)) {
+			t.Errorf("parseTraceparent(%q) = %q, %q, %v", tt.input, gotTrace, gotSpan, ok)
+		}
+	}
+}
+
+func TestParseTruncate(t *testing.T) {
+	tests := []struct {
+		input string
+		want  int
+		ok    bool
+	}{
+		{"64KB", 64000, true},
+		{"64KiB", 64 << 10, true},
+		{"512", 512, true},
+		{"16MiB", MaxTruncateOverride, true},
+		{"17MiB", 0, false},
+		{"0", 0, false},
+		{"", 0, false},
+		{"abc", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseTruncate(tt.input)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("parseTruncate(%q) = %d, %v", tt.input, got, ok)
+		}
+	}
+}
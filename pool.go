@@ -0,0 +1,68 @@
+package zlog
+
+import (
+	"bytes"
+	"sync"
+	"sync/atomic"
+)
+
+// maxPooledBuffer 超过这个容量的 buffer 不放回池子, 避免偶尔的大请求长期占用内存
+const maxPooledBuffer = 64 << 10
+
+var bufPool = sync.Pool{
+	New: func() interface{} {
+		return new(bytes.Buffer)
+	},
+}
+
+func getBuffer() *bytes.Buffer {
+	buf := bufPool.Get().(*bytes.Buffer)
+	buf.Reset()
+	return buf
+}
+
+func putBuffer(buf *bytes.Buffer) {
+	if buf == nil || buf.Cap() > maxPooledBuffer {
+		return
+	}
+	bufPool.Put(buf)
+}
+
+// bufferedBytes 所有请求当前缓存的 body 字节数
+var bufferedBytes atomic.Int64
+
+// reserve 从 max_buffer_memory 中预留 n 个字节, 超过上限时返回 0
+func (p *proxyWriter) reserve(n int) int {
+	limit := int64(p.z.MaxBufferMemory)
+	if limit <= 0 {
+		return n
+	}
+	if bufferedBytes.Add(int64(n)) > limit {
+		bufferedBytes.Add(-int64(n))
+		return 0
+	}
+	p.reserved.Add(int64(n))
+	return n
+}
+
+func (p *proxyWriter) release() {
+	if n := p.reserved.Swap(0); n > 0 {
+		bufferedBytes.Add(-n)
+	}
+}
+
+// growBuffer 按 buffer_hint 预分配容量, 不超过这个 buffer 的截断大小和 maxPooledBuffer
+// 超过 maxPooledBuffer 的 buffer 不会放回池子, 每个请求都要重新分配
+// 池子里拿到的 buffer 容量已经够时不会重新分配
+func growBuffer(buf *bytes.Buffer, hint uint64, truncate int) {
+	n := int(hint)
+	if n > truncate {
+		n = truncate
+	}
+	if n > maxPooledBuffer {
+		n = maxPooledBuffer
+	}
+	if n > 0 {
+		buf.Grow(n)
+	}
+}
@@ -0,0 +1,33 @@
+package zlog
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestGrowBuffer(t *testing.T) {
+	tests := []struct {
+		name     string
+		hint     uint64
+		truncate int
+		want     int
+	}{
+		{"hint", 4 << 10, 16 << 10, 4 << 10},
+		{"capped by truncate", 16 << 10, 1 << 10, 1 << 10},
+		{"capped by pool", 1 << 20, 1 << 20, maxPooledBuffer},
+		{"no truncate", 4 << 10, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			growBuffer(&buf, tt.hint, tt.truncate)
+			// Grow 可能多分配一点, 但不能超过池子的上限
+			if buf.Cap() < tt.want || buf.Cap() > maxPooledBuffer {
+				t.Fatalf("cap = %d, want at least %d and at most %d", buf.Cap(), tt.want, maxPooledBuffer)
+			}
+			if tt.want == 0 && buf.Cap() != 0 {
+				t.Fatalf("cap = %d, want no allocation", buf.Cap())
+			}
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package zlog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"regexp"
+	"strings"
+)
+
+// redactMask 脱敏后的值
+const redactMask = "***"
+
+// redactJSON 递归替换 json 中需要脱敏的字段, 直接修改 v
+func (z *ZLog) redactJSON(v interface{}) interface{} {
+	if len(z.RedactJSONFields) == 0 {
+		return v
+	}
+	switch obj := v.(type) {
+	case map[string]interface{}:
+		for k, child := range obj {
+			if z.redactField(k) {
+				obj[k] = redactMask
+			} else {
+				obj[k] = z.redactJSON(child)
+			}
+		}
+	case []interface{}:
+		for i := range obj {
+			obj[i] = z.redactJSON(obj[i])
+		}
+	}
+	return v
+}
+
+func (z *ZLog) redactField(key string) bool {
+	for _, f := range z.RedactJSONFields {
+		if strings.EqualFold(f, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// builtinRedactPatterns redact_pattern 内置的正则
+var builtinRedactPatterns = map[string]string{
+	"credit_card": `\b\d(?:[ -]?\d){12,18}\b`,
+	"email":       `[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`,
+	"ipv4":        `\b(?:\d{1,3}\.){3}\d{1,3}\b`,
+}
+
+// redactValidators 内置正则匹配之后的校验, 通过校验的才替换
+// credit_card 只看数字的长度会误伤毫秒时间戳和订单号, 这里再做一次 Luhn 校验
+var redactValidators = map[string]func(string) bool{
+	builtinRedactPatterns["credit_card"]: luhnValid,
+}
+
+// luhnValid 去掉空格和连字符之后按 Luhn 算法校验卡号
+func luhnValid(s string) bool {
+	sum, n := 0, 0
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c == ' ' || c == '-' {
+			continue
+		}
+		d := int(c - '0')
+		if n%2 == 1 {
+			if d *= 2; d > 9 {
+				d -= 9
+			}
+		}
+		sum += d
+		n++
+	}
+	return n > 0 && sum%10 == 0
+}
+
+func compileRedactPattern(pattern string) (*regexp.Regexp, error) {
+	if builtin, ok := builtinRedactPatterns[pattern]; ok {
+		pattern = builtin
+	}
+	return regexp.Compile(pattern)
+}
+
+// maskPatterns 替换匹配 redact_pattern 的内容
+// 传进来的是已经截断的 body, 正则的开销和 truncate 成正比
+func (z *ZLog) maskPatterns(s string) string {
+	for _, re := range z.redactRegex {
+		valid, ok := redactValidators[re.String()]
+		if !ok {
+			s = re.ReplaceAllLiteralString(s, redactMask)
+			continue
+		}
+		s = re.ReplaceAllStringFunc(s, func(m string) string {
+			if valid(m) {
+				return redactMask
+			}
+			return m
+		})
+	}
+	return s
+}
+
+// cookies 按 log_cookies 的顺序取出 cookie, 值只保留 sha256 的前 8 位
+// 足够判断两个请求是不是同一个会话, 又不会泄露 cookie 本身
+func (p *proxyWriter) cookies() (out []headerField) {
+	if len(p.z.LogCookies) == 0 {
+		return
+	}
+	for _, name := range p.z.LogCookies {
+		c, err := p.req.Cookie(name)
+		if err != nil {
+			continue
+		}
+		sum := sha256.Sum256([]byte(c.Value))
+		out = append(out, headerField{name: c.Name, value: hex.EncodeToString(sum[:4])})
+	}
+	return
+}
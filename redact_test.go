@@ -0,0 +1,72 @@
+package zlog
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestMaskCreditCard(t *testing.T) {
+	re, err := compileRedactPattern("credit_card")
+	if err != nil {
+		t.Fatal(err)
+	}
+	z := &ZLog{redactRegex: []*regexp.Regexp{re}}
+	tests := []struct {
+		input, want string
+	}{
+		{"card 4111111111111111 ok", "card *** ok"},
+		{"card 4111-1111-1111-1111", "card ***"},
+		{"card 5500 0000 0000 0004", "card ***"},
+		{"amex 378282246310005", "amex ***"},
+		// 不能通过 Luhn 校验的长数字保持原样
+		{"ts 1700000000000 id", "ts 1700000000000 id"},
+		{"order 4111111111111112", "order 4111111111111112"},
+		{"snowflake 1541815603606036480", "snowflake 1541815603606036480"},
+		{"short 12345", "short 12345"},
+	}
+	for _, tt := range tests {
+		if got := z.maskPatterns(tt.input); got != tt.want {
+			t.Errorf("maskPatterns(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestLuhnValid(t *testing.T) {
+	tests := []struct {
+		input string
+		want  bool
+	}{
+		{"4111111111111111", true},
+		{"4111 1111 1111 1111", true},
+		{"79927398713", true},
+		{"79927398710", false},
+		{"0", true},
+		{"", false},
+		{"--", false},
+	}
+	for _, tt := range tests {
+		if got := luhnValid(tt.input); got != tt.want {
+			t.Errorf("luhnValid(%q) = %v", tt.input, got)
+		}
+	}
+}
+
+func TestRedactHeader(t *testing.T) {
+	tests := []struct {
+		name, value, want string
+	}{
+		{"Authorization", "Bearer abc.def", "Bearer ***"},
+		{"Proxy-Authorization", "Basic dXNlcjpwYXNz", "Basic ***"},
+		{"Authorization", "token", "***"},
+		// 不是认证头时空格前的部分同样是密钥
+		{"X-Api-Key", "supersecret tail", "***"},
+		{"X-Api-Key", "supersecret", "***"},
+		{"Cookie", "a=1; b=2", "a=***; b=***"},
+		{"Set-Cookie", "id=abc; Path=/", "id=***"},
+	}
+	for _, tt := range tests {
+		if got := redactHeader(tt.name, tt.value); got != tt.want {
+			t.Errorf("redactHeader(%q, %q) = %q, want %q", tt.name, tt.value, got, tt.want)
+		}
+	}
+}
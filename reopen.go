@@ -0,0 +1,86 @@
+package zlog
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/caddyserver/caddy/v2/modules/logging"
+	"go.uber.org/zap"
+)
+
+// startReopen 收到 SIGHUP 时重新打开日志文件
+// 外部的 logrotate 移走文件之后, 旧的句柄还指向被移走的文件, 需要重新打开才能写到新文件
+// caddy 自己会忽略 SIGHUP, 重新加载配置时 Provision 总是会打开新的句柄, 不需要这个选项
+func (z *ZLog) startReopen() error {
+	if z.LogFile == nil {
+		return fmt.Errorf("reopen_on_signal requires file_name")
+	}
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGHUP)
+	z.stopReopening = make(chan struct{})
+	stop := z.stopReopening
+	go func() {
+		defer signal.Stop(sig)
+		for {
+			select {
+			case <-stop:
+				return
+			case <-sig:
+				if err := z.reopen(); err != nil {
+					z.logger.Error("zlog reopen log file", zap.Error(err))
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+func (z *ZLog) stopReopen() {
+	if z.stopReopening != nil {
+		close(z.stopReopening)
+		z.stopReopening = nil
+	}
+}
+
+// reopen 打开新的句柄替换文件输出, 再关闭旧的句柄
+// 按状态码分类的文件也会重新打开
+func (z *ZLog) reopen() error {
+	z.outputMu.Lock()
+	defer z.outputMu.Unlock()
+	for i, s := range z.sinks {
+		ss, isStatus := s.(statusSink)
+		if isStatus {
+			s = ss.Sink
+		}
+		ws, ok := s.(writerSink)
+		if !ok {
+			continue
+		}
+		var fw *logging.FileWriter
+		switch {
+		case isStatus && ss.fw != nil:
+			fw = ss.fw
+		case ws.w == z.LogFile:
+			fw = &z.FileWriter
+		default:
+			continue
+		}
+		w, err := fw.OpenWriter()
+		if err != nil {
+			return fmt.Errorf("open log file %s: %v", fw.Filename, err)
+		}
+		if isStatus {
+			ss.Sink = writerSink{w}
+			z.sinks[i] = ss
+		} else {
+			z.sinks[i] = writerSink{w}
+		}
+		if ws.w == z.LogFile {
+			z.LogFile = w
+		}
+		ws.Close()
+	}
+	return z.reopenSlowFile()
+}
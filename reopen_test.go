@@ -0,0 +1,108 @@
+package zlog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// openFiles 当前进程打开的文件数, 只在 linux 上可用
+func openFiles(t *testing.T) int {
+	t.Helper()
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skip("no /proc/self/fd")
+	}
+	return len(entries)
+}
+
+func readFile(t *testing.T, name string) string {
+	t.Helper()
+	data, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+// 模拟 logrotate 移走文件后 caddy 重新加载配置: 新的实例打开新文件, 旧的实例关闭句柄
+func TestReloadReopensFile(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "access.log")
+	before := openFiles(t)
+	request := func(z *ZLog, path string) {
+		t.Helper()
+		err := serveTest(z, httptest.NewRecorder(), newTestRequest("GET", path, nil), func(w http.ResponseWriter, r *http.Request) error {
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	old := &ZLog{FileName: name}
+	provisionTest(t, old)
+	request(old, "/before")
+	if err := os.Rename(name, name+".1"); err != nil {
+		t.Fatal(err)
+	}
+	// caddy 先 Provision 新的配置, 再 Cleanup 旧的
+	z := &ZLog{FileName: name}
+	provisionTest(t, z)
+	old.Cleanup()
+	request(z, "/after")
+	z.Cleanup()
+
+	if got := readFile(t, name+".1"); !strings.Contains(got, "/before") || strings.Contains(got, "/after") {
+		t.Errorf("rotated file: %q", got)
+	}
+	if got := readFile(t, name); !strings.Contains(got, "/after") || strings.Contains(got, "/before") {
+		t.Errorf("new file: %q", got)
+	}
+	if after := openFiles(t); after != before {
+		t.Errorf("open files: %d before, %d after reload", before, after)
+	}
+}
+
+// Cleanup 时还在处理的请求, 例如 Shutdown 之后的 websocket, 结束后的日志直接丢弃, 用 -race 运行
+func TestCleanupWithRequestInFlight(t *testing.T) {
+	z := &ZLog{FileName: filepath.Join(t.TempDir(), "access.log")}
+	sink := provisionTest(t, z)
+	entered, release := make(chan struct{}), make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		done <- serveTest(z, httptest.NewRecorder(), newTestRequest("GET", "/ws", nil), func(w http.ResponseWriter, r *http.Request) error {
+			close(entered)
+			<-release
+			return nil
+		})
+	}()
+	<-entered
+	cleaned := make(chan struct{})
+	go func() {
+		defer close(cleaned)
+		z.Cleanup()
+	}()
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	<-cleaned
+	z.Cleanup()
+	if z.Dropped() == 0 && len(sink.lines()) != 1 {
+		t.Fatalf("late entry neither written nor dropped")
+	}
+	// Cleanup 之后的请求不会再写到已经关闭的文件
+	before := len(sink.lines())
+	if err := serveTest(z, httptest.NewRecorder(), newTestRequest("GET", "/late", nil), func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(sink.lines()) != before {
+		t.Fatalf("entry written after cleanup: %q", sink.lines())
+	}
+}
@@ -0,0 +1,19 @@
+package zlog
+
+// requestBody 替换 r.Body 的包装, 只暴露 Read 和 Close
+// proxyWriter 本身也是 ResponseWriter, 直接作为 r.Body 时下游断言 io.Writer 或 io.ReaderFrom 会拿到写响应的方法
+//
+// 读取是流式透传的: 每次 Read 直接读底层的 body, 只把前 reqTruncate 个字节拷贝到 reqBuf, 其余只统计大小和摘要
+// 大的上传不会整个缓存在内存里, 下游边读边处理也不受影响
+// net/http 的请求 body 只实现了 Read 和 Close, 这里也不提供 Seek 和 WriterTo, 往回 seek 会让捕获的内容和实际读到的不一致
+type requestBody struct {
+	p *proxyWriter
+}
+
+func (b requestBody) Read(p []byte) (int, error) {
+	return b.p.Read(p)
+}
+
+func (b requestBody) Close() error {
+	return b.p.Close()
+}
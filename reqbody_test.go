@@ -0,0 +1,167 @@
+package zlog
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// handler 返回之后还在读 body 的 goroutine 不能写入已经还回池子的 buffer
+func TestReadAfterHandlerReturns(t *testing.T) {
+	z := &ZLog{Format: FormatJSON}
+	sink := provisionTest(t, z)
+	pr, pw := io.Pipe()
+	r := newTestRequest("POST", "/", pr)
+	start, done := make(chan struct{}), make(chan struct{})
+	err := serveTest(z, httptest.NewRecorder(), r, func(w http.ResponseWriter, r *http.Request) error {
+		go func() {
+			defer close(done)
+			<-start
+			io.Copy(io.Discard, r.Body)
+		}()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	close(start)
+	io.WriteString(pw, "late body")
+	pw.Close()
+	<-done
+
+	lines := sink.lines()
+	if len(lines) != 1 {
+		t.Fatalf("want one entry, got %q", lines)
+	}
+	if body, _ := decodeEntry(t, lines[0])["req_body"].(string); body != "" {
+		t.Fatalf("late read captured: %q", body)
+	}
+}
+
+// 大的上传边读边透传给下游, 下游拿到完整的 body, 只缓存 truncate 大小
+func TestLargeUploadStreams(t *testing.T) {
+	z := &ZLog{Format: FormatJSON, Truncate: 1024}
+	sink := provisionTest(t, z)
+	data := make([]byte, 4<<20)
+	for i := range data {
+		data[i] = 'a' + byte(i%26)
+	}
+	want := sha256.Sum256(data)
+	r := newTestRequest("POST", "/upload", bytes.NewReader(data))
+	r.Header.Set("Content-Type", "text/plain")
+	var got [sha256.Size]byte
+	var n int64
+	err := serveTest(z, httptest.NewRecorder(), r, func(w http.ResponseWriter, r *http.Request) error {
+		h := sha256.New()
+		var err error
+		n, err = io.CopyBuffer(h, r.Body, make([]byte, 32<<10))
+		h.Sum(got[:0])
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(data)) || got != want {
+		t.Fatalf("handler read %d bytes, body changed: %v", n, got != want)
+	}
+	m := decodeEntry(t, sink.lines()[0])
+	if m["req_size"] != float64(len(data)) {
+		t.Errorf("req_size = %v", m["req_size"])
+	}
+	body, _ := m["req_body"].(string)
+	if want := string(data[:1024]) + "...[truncated, total 4194304 bytes]"; body != want {
+		t.Errorf("req_body has %d bytes, want the first 1024 and a marker", len(body))
+	}
+}
+
+// Expect: 100-continue 的请求被直接拒绝时, 客户端不会发送 body, 日志照常写出
+func TestExpectContinueRejected(t *testing.T) {
+	z := &ZLog{Format: FormatJSON}
+	sink := provisionTest(t, z)
+	handled := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled <- serveTest(z, w, withTestContext(r), func(w http.ResponseWriter, r *http.Request) error {
+			w.WriteHeader(http.StatusForbidden)
+			return nil
+		})
+	}))
+	defer srv.Close()
+
+	body := &countingReader{r: strings.NewReader(strings.Repeat("a", 1024))}
+	req, err := http.NewRequest("PUT", srv.URL, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.ContentLength = 1024
+	req.Header.Set("Expect", "100-continue")
+	client := &http.Client{Transport: &http.Transport{ExpectContinueTimeout: 5 * time.Second}}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("client got status %d", resp.StatusCode)
+	}
+	if err := <-handled; err != nil {
+		t.Fatal(err)
+	}
+	if body.n.Load() != 0 {
+		t.Fatalf("client sent %d body bytes", body.n.Load())
+	}
+
+	lines := sink.lines()
+	if len(lines) != 1 {
+		t.Fatalf("want one entry, got %q", lines)
+	}
+	m := decodeEntry(t, lines[0])
+	if m["status"] != float64(http.StatusForbidden) {
+		t.Errorf("status = %v", m["status"])
+	}
+	// 下游没有读 body, 大小取自 Content-Length
+	if m["req_size"] != float64(1024) || m["req_size_declared"] != true {
+		t.Errorf("req_size = %v declared = %v", m["req_size"], m["req_size_declared"])
+	}
+	if got, _ := m["req_body"].(string); got != "" {
+		t.Errorf("req_body = %q", got)
+	}
+}
+
+// countingReader 统计客户端实际发出的 body 字节数
+type countingReader struct {
+	r io.Reader
+	n atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
+
+func BenchmarkServeHTTP(b *testing.B) {
+	z := &ZLog{}
+	provisionTest(b, z)
+	body := strings.Repeat("a", 512)
+	h := func(w http.ResponseWriter, r *http.Request) error {
+		if _, err := io.Copy(io.Discard, r.Body); err != nil {
+			return err
+		}
+		_, err := io.WriteString(w, body)
+		return err
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r := newTestRequest("POST", "/bench", strings.NewReader(body))
+		if err := serveTest(z, httptest.NewRecorder(), r, h); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package zlog
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// rotator 开启滚动时 FileWriter 返回的 lumberjack.Logger 实现了 Rotate
+type rotator interface {
+	Rotate() error
+}
+
+// startRoll 每隔 RollInterval 滚动一次日志文件
+// 滚动时间按 UTC 对齐到 RollInterval 的整数倍, 例如 24h 会在每天 UTC 零点滚动
+// reopen 之后 LogFile 会被替换, 所以每次滚动时重新取
+func (z *ZLog) startRoll() error {
+	if _, ok := z.LogFile.(rotator); !ok {
+		return fmt.Errorf("roll_interval requires file_name with rolling enabled")
+	}
+	interval := time.Duration(z.RollInterval)
+	z.stopRolling = make(chan struct{})
+	stop := z.stopRolling
+	go func() {
+		for {
+			now := time.Now()
+			timer := time.NewTimer(now.Truncate(interval).Add(interval).Sub(now))
+			select {
+			case <-stop:
+				timer.Stop()
+				return
+			case <-timer.C:
+				if err := z.rotate(); err != nil {
+					z.logger.Error("zlog rotate log file", zap.Error(err))
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// rotate 和 reopen 覆盖的文件一致, 主日志文件, 按状态码分类的文件和 slow_file 一起滚动
+func (z *ZLog) rotate() error {
+	z.outputMu.Lock()
+	defer z.outputMu.Unlock()
+	var errs []error
+	rotate := func(w io.Writer) {
+		if r, ok := w.(rotator); ok {
+			if err := r.Rotate(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	rotate(z.LogFile)
+	for _, s := range z.sinks {
+		if ss, ok := s.(statusSink); ok && ss.fw != nil {
+			if ws, ok := ss.Sink.(writerSink); ok {
+				rotate(ws.w)
+			}
+		}
+	}
+	if ws, ok := z.slowSink.(writerSink); ok {
+		rotate(ws.w)
+	}
+	return errors.Join(errs...)
+}
+
+func (z *ZLog) stopRoll() {
+	if z.stopRolling != nil {
+		close(z.stopRolling)
+		z.stopRolling = nil
+	}
+}
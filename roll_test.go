@@ -0,0 +1,106 @@
+package zlog
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/logging"
+)
+
+// rotate 要连同按状态码分类的文件和 slow_file 一起滚动
+func TestRotateAllFiles(t *testing.T) {
+	dir := t.TempDir()
+	z := &ZLog{
+		FileName:      filepath.Join(dir, "access.log"),
+		StatusFiles:   map[string]*logging.FileWriter{"5xx": {Filename: filepath.Join(dir, "error.log")}},
+		SlowThreshold: 1,
+		SlowFile:      &logging.FileWriter{Filename: filepath.Join(dir, "slow.log")},
+	}
+	provisionTest(t, z)
+	for _, status := range []int{http.StatusOK, http.StatusInternalServerError} {
+		err := serveTest(z, httptest.NewRecorder(), newTestRequest("GET", "/", nil), func(w http.ResponseWriter, r *http.Request) error {
+			w.WriteHeader(status)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := z.rotate(); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"access", "error", "slow"} {
+		n := 0
+		for _, e := range entries {
+			if strings.HasPrefix(e.Name(), name) {
+				n++
+			}
+		}
+		// 滚动后留下一个备份和一个新文件
+		if n != 2 {
+			t.Errorf("%s: want 2 files after rotate, got %d", name, n)
+		}
+	}
+}
+
+// started 日志没有状态码, 只写到默认文件, 同样受 max_line 和 rate_limit 限制
+func TestStartLogRouting(t *testing.T) {
+	dir := t.TempDir()
+	z := &ZLog{
+		FileName: filepath.Join(dir, "access.log"),
+		StatusFiles: map[string]*logging.FileWriter{
+			"2xx": {Filename: filepath.Join(dir, "ok.log")},
+			"5xx": {Filename: filepath.Join(dir, "error.log")},
+		},
+		StartLog: true,
+		MaxLine:  80,
+	}
+	provisionTest(t, z)
+	path := "/" + strings.Repeat("x", 200)
+	err := serveTest(z, httptest.NewRecorder(), newTestRequest("GET", path, nil), func(w http.ResponseWriter, r *http.Request) error {
+		w.WriteHeader(http.StatusInternalServerError)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	z.Cleanup()
+	access, _ := os.ReadFile(filepath.Join(dir, "access.log"))
+	if !strings.Contains(string(access), " started ") {
+		t.Errorf("start line missing from default file: %q", access)
+	}
+	if n := len(strings.TrimSuffix(string(access), "\n")); n > 80 {
+		t.Errorf("start line not capped by max_line: %d bytes", n)
+	}
+	ok, _ := os.ReadFile(filepath.Join(dir, "ok.log"))
+	if len(ok) != 0 {
+		t.Errorf("start line routed to file_2xx: %q", ok)
+	}
+	errLog, _ := os.ReadFile(filepath.Join(dir, "error.log"))
+	if strings.Count(string(errLog), "\n") != 1 || strings.Contains(string(errLog), " started ") {
+		t.Errorf("error file: %q", errLog)
+	}
+}
+
+func TestStartLogRateLimit(t *testing.T) {
+	z := &ZLog{StartLog: true, RateLimit: 1}
+	sink := provisionTest(t, z)
+	err := serveTest(z, httptest.NewRecorder(), newTestRequest("GET", "/", nil), func(w http.ResponseWriter, r *http.Request) error {
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 一秒一条, 开始日志用掉了唯一的名额
+	if lines := sink.lines(); len(lines) != 1 || !strings.Contains(lines[0], " started ") || z.Dropped() != 1 {
+		t.Fatalf("lines %q, dropped %d", lines, z.Dropped())
+	}
+}
@@ -0,0 +1,131 @@
+package zlog
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/caddyserver/caddy/v2/modules/logging"
+)
+
+// Sink 日志输出, 每行日志只格式化一次, 然后依次交给所有的 Sink
+// status 是响应状态码, 给 syslog 这类需要日志级别的输出使用
+// Write 返回后 line 会被复用, 需要保留的话要自己拷贝
+type Sink interface {
+	Write(line []byte, status int) error
+	Close() error
+}
+
+// writerSink 写到文件或者标准输出
+type writerSink struct {
+	w io.Writer
+}
+
+func (s writerSink) Write(line []byte, status int) error {
+	_, err := s.w.Write(line)
+	return err
+}
+
+// Close 只关闭文件, 标准输出和标准错误不需要关闭
+func (s writerSink) Close() error {
+	if c, ok := s.w.(io.Closer); ok && s.w != os.Stdout && s.w != os.Stderr {
+		return c.Close()
+	}
+	return nil
+}
+
+// statusSink 只写入状态码属于 class 的日志, 例如 4 表示 4xx
+// class 为 0 时是默认的文件, 只写入没有单独文件的分类
+type statusSink struct {
+	Sink
+	class   int
+	classes map[int]bool
+	// fw 打开这个文件的配置, 重新打开文件时使用, 默认文件为 nil
+	fw *logging.FileWriter
+}
+
+func (s statusSink) Write(line []byte, status int) error {
+	class := status / 100
+	// 没有写响应头时状态码按 200 处理
+	if status == 0 {
+		class = 2
+	}
+	if s.class == 0 && s.classes[class] || s.class != 0 && s.class != class {
+		return nil
+	}
+	return s.Sink.Write(line, status)
+}
+
+// parseStatusClass 2xx 这样的分类, 返回 2
+func parseStatusClass(class string) (int, error) {
+	if len(class) != 3 || class[0] < '1' || class[0] > '5' || class[1:] != "xx" {
+		return 0, fmt.Errorf("invalid status class: %s", class)
+	}
+	return int(class[0] - '0'), nil
+}
+
+// provisionSinks 按 文件, 标准输出, 标准错误, webhook, syslog, unix socket 的顺序创建输出
+func (z *ZLog) provisionSinks() error {
+	if z.FileWriter.Filename != "" {
+		var err error
+		if z.LogFile, err = z.FileWriter.OpenWriter(); err != nil {
+			return fmt.Errorf("open log file %s: %v", z.FileWriter.Filename, err)
+		}
+		z.sinks = append(z.sinks, writerSink{z.LogFile})
+	}
+	if len(z.StatusFiles) > 0 {
+		if err := z.provisionStatusFiles(); err != nil {
+			return err
+		}
+	}
+	if !z.DisableStdout {
+		z.sinks = append(z.sinks, writerSink{os.Stdout})
+	}
+	if z.Stderr {
+		z.sinks = append(z.sinks, writerSink{os.Stderr})
+	}
+	if z.HTTPSink != "" {
+		z.sinks = append(z.sinks, z.newHTTPSink())
+	}
+	if z.SyslogAddress != "" {
+		s, err := z.newSyslogSink()
+		if err != nil {
+			return fmt.Errorf("dial syslog %s: %v", z.SyslogAddress, err)
+		}
+		z.sinks = append(z.sinks, s)
+	}
+	if z.Socket != "" {
+		s, err := z.newSocketSink()
+		if err != nil {
+			return fmt.Errorf("dial socket %s: %v", z.Socket, err)
+		}
+		z.sinks = append(z.sinks, s)
+	}
+	return nil
+}
+
+// provisionStatusFiles 打开按状态码分类的文件, 默认文件改为只写入其余的分类
+func (z *ZLog) provisionStatusFiles() error {
+	classes := make(map[int]bool, len(z.StatusFiles))
+	for name := range z.StatusFiles {
+		class, err := parseStatusClass(name)
+		if err != nil {
+			return err
+		}
+		classes[class] = true
+	}
+	for i, s := range z.sinks {
+		if ws, ok := s.(writerSink); ok && ws.w == z.LogFile {
+			z.sinks[i] = statusSink{Sink: s, classes: classes}
+		}
+	}
+	for name, fw := range z.StatusFiles {
+		class, _ := parseStatusClass(name)
+		w, err := fw.OpenWriter()
+		if err != nil {
+			return fmt.Errorf("open log file %s: %v", fw.Filename, err)
+		}
+		z.sinks = append(z.sinks, statusSink{Sink: writerSink{w}, class: class, fw: fw})
+	}
+	return nil
+}
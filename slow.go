@@ -0,0 +1,41 @@
+package zlog
+
+import (
+	"fmt"
+	"time"
+)
+
+// isSlow 超过 slow_threshold 的请求
+func (z *ZLog) isSlow(d time.Duration) bool {
+	return z.SlowThreshold > 0 && d > time.Duration(z.SlowThreshold)
+}
+
+// provisionSlowFile 打开 slow_file, 慢请求除了正常的输出之外再写一份
+func (z *ZLog) provisionSlowFile() error {
+	w, err := z.SlowFile.OpenWriter()
+	if err != nil {
+		return fmt.Errorf("open log file %s: %v", z.SlowFile.Filename, err)
+	}
+	z.slowSink = writerSink{w}
+	return nil
+}
+
+// writeSlow 调用时已经持有 outputMu
+func (z *ZLog) writeSlow(data []byte, status int) error {
+	if z.slowSink == nil {
+		return nil
+	}
+	return z.slowSink.Write(data, status)
+}
+
+// reopenSlowFile 和 reopen 一起在 outputMu 下调用
+func (z *ZLog) reopenSlowFile() error {
+	if z.slowSink == nil {
+		return nil
+	}
+	old := z.slowSink
+	if err := z.provisionSlowFile(); err != nil {
+		return err
+	}
+	return old.Close()
+}
@@ -0,0 +1,76 @@
+package zlog
+
+import (
+	"net"
+	"sync"
+	"time"
+)
+
+// socketWriteTimeout 写 unix socket 的超时, 读端卡住时不会一直占着 outputMu
+const socketWriteTimeout = time.Second
+
+// socketSink 把日志按行写到 unix domain socket, 例如 fluent-bit 的 unix input
+// 写失败时重连一次, 连续失败时熔断
+type socketSink struct {
+	path string
+
+	dropped func(n int)
+	breaker *breaker
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (z *ZLog) newSocketSink() (*socketSink, error) {
+	s := &socketSink{
+		path:    z.Socket,
+		dropped: z.countDropped,
+		breaker: z.newBreaker(),
+	}
+	return s, s.dial()
+}
+
+func (s *socketSink) dial() (err error) {
+	s.conn, err = net.Dial("unix", s.path)
+	return
+}
+
+func (s *socketSink) Write(line []byte, status int) error {
+	if !s.breaker.allow() {
+		s.dropped(1)
+		return nil
+	}
+	err := s.write(line)
+	s.breaker.done(err)
+	return err
+}
+
+func (s *socketSink) write(line []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		s.conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+		if _, err := s.conn.Write(line); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	if err := s.dial(); err != nil {
+		return err
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(socketWriteTimeout))
+	_, err := s.conn.Write(line)
+	return err
+}
+
+func (s *socketSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
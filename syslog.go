@@ -0,0 +1,145 @@
+package zlog
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// syslog 严重级别
+const (
+	syslogSeverityError   = 3
+	syslogSeverityWarning = 4
+	syslogSeverityInfo    = 6
+	syslogSeverityDebug   = 7
+)
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3,
+	"auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogTimeout 连接和写 syslog 的超时, syslog 卡住时不会一直占着 outputMu
+const syslogTimeout = time.Second
+
+// syslogSink 以 RFC 5424 格式发送日志, 写失败时重连一次
+type syslogSink struct {
+	network  string
+	address  string
+	facility int
+	tag      string
+	hostname string
+	// sep 行分隔符, syslog 自己分帧, 发送前去掉
+	sep []byte
+
+	// severity 状态码对应的严重级别, 和 level_map 一致
+	severity func(status int) int
+	// dropped breaker 熔断时丢弃日志的回调
+	dropped func(n int)
+	breaker *breaker
+
+	mu   sync.Mutex
+	conn net.Conn
+}
+
+func (z *ZLog) newSyslogSink() (*syslogSink, error) {
+	s := &syslogSink{
+		network:  z.SyslogNetwork,
+		address:  z.SyslogAddress,
+		facility: syslogFacilities["local0"],
+		tag:      z.SyslogTag,
+		sep:      []byte(z.lineSeparator()),
+		severity: z.syslogSeverity,
+		dropped:  z.countDropped,
+		breaker:  z.newBreaker(),
+	}
+	if s.network == "" {
+		s.network = "udp"
+	}
+	if f, ok := syslogFacilities[z.SyslogFacility]; ok {
+		s.facility = f
+	}
+	if s.tag == "" {
+		s.tag = "zlog"
+	}
+	s.hostname, _ = os.Hostname()
+	if s.hostname == "" {
+		s.hostname = "-"
+	}
+	return s, s.dial()
+}
+
+func (s *syslogSink) dial() (err error) {
+	if s.network != "unix" {
+		s.conn, err = net.DialTimeout(s.network, s.address, syslogTimeout)
+		return
+	}
+	// 本地的 /dev/log 一般是 unixgram
+	if s.conn, err = net.DialTimeout("unixgram", s.address, syslogTimeout); err != nil {
+		s.conn, err = net.DialTimeout("unix", s.address, syslogTimeout)
+	}
+	return
+}
+
+// format <PRI>1 TIMESTAMP HOSTNAME APP-NAME PROCID MSGID SD MSG
+func (s *syslogSink) format(line []byte, status int) []byte {
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "<%d>1 %s %s %s %d - - ",
+		s.facility*8+s.severity(status),
+		time.Now().UTC().Format(time.RFC3339Nano),
+		s.hostname, s.tag, os.Getpid())
+	msg.Write(bytes.TrimSuffix(line, s.sep))
+	if s.network == "udp" || s.network == "unix" {
+		return msg.Bytes()
+	}
+	// tcp 使用 RFC 6587 的 octet counting 分帧
+	return append([]byte(strconv.Itoa(msg.Len())+" "), msg.Bytes()...)
+}
+
+// Write 熔断时直接丢弃, 不会阻塞在连接不上的 syslog 上
+func (s *syslogSink) Write(line []byte, status int) error {
+	if !s.breaker.allow() {
+		s.dropped(1)
+		return nil
+	}
+	err := s.write(s.format(line, status))
+	s.breaker.done(err)
+	return err
+}
+
+func (s *syslogSink) write(msg []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn != nil {
+		s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+		if _, err := s.conn.Write(msg); err == nil {
+			return nil
+		}
+		s.conn.Close()
+		s.conn = nil
+	}
+	if err := s.dial(); err != nil {
+		return err
+	}
+	s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	_, err := s.conn.Write(msg)
+	return err
+}
+
+func (s *syslogSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		return nil
+	}
+	err := s.conn.Close()
+	s.conn = nil
+	return err
+}
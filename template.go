@@ -0,0 +1,118 @@
+package zlog
+
+import (
+	"bytes"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// templateFields format_template 支持的占位符
+var templateFields = map[string]func(p *proxyWriter, e *Entry) string{
+	"ts":          func(p *proxyWriter, e *Entry) string { return p.z.formatTime(e.Time) },
+	"client_ip":   func(p *proxyWriter, e *Entry) string { return e.ClientIP },
+	"duration":    func(p *proxyWriter, e *Entry) string { return e.Duration.String() },
+	"duration_ms": func(p *proxyWriter, e *Entry) string { return strconv.FormatFloat(durationMs(e.Duration), 'f', 3, 64) },
+	"request_read_ms": func(p *proxyWriter, e *Entry) string {
+		return strconv.FormatFloat(durationMs(e.ReadTime), 'f', 3, 64)
+	},
+	"upstream_ms": func(p *proxyWriter, e *Entry) string {
+		return strconv.FormatFloat(durationMs(e.UpstreamTime), 'f', 3, 64)
+	},
+	"response_write_ms": func(p *proxyWriter, e *Entry) string {
+		return strconv.FormatFloat(durationMs(e.WriteTime), 'f', 3, 64)
+	},
+	"status":              func(p *proxyWriter, e *Entry) string { return strconv.Itoa(e.Status) },
+	"level":               func(p *proxyWriter, e *Entry) string { return e.Level },
+	"method":              func(p *proxyWriter, e *Entry) string { return e.Method },
+	"scheme":              func(p *proxyWriter, e *Entry) string { return e.Scheme },
+	"host":                func(p *proxyWriter, e *Entry) string { return e.Host },
+	"path":                func(p *proxyWriter, e *Entry) string { return e.Path },
+	"proto":               func(p *proxyWriter, e *Entry) string { return e.Proto },
+	"trace_id":            func(p *proxyWriter, e *Entry) string { return e.TraceID },
+	"route":               func(p *proxyWriter, e *Entry) string { return e.Route },
+	"span_id":             func(p *proxyWriter, e *Entry) string { return e.SpanID },
+	"request_uuid":        func(p *proxyWriter, e *Entry) string { return e.RequestUUID },
+	"graphql_operation":   func(p *proxyWriter, e *Entry) string { return e.GraphQLOperation },
+	"graphql_query":       func(p *proxyWriter, e *Entry) string { return e.GraphQLQuery },
+	"user":                func(p *proxyWriter, e *Entry) string { return e.User },
+	"user_agent":          func(p *proxyWriter, e *Entry) string { return e.UserAgent },
+	"referer":             func(p *proxyWriter, e *Entry) string { return e.Referer },
+	"client_disconnected": func(p *proxyWriter, e *Entry) string { return strconv.FormatBool(e.ClientDisconnected) },
+	"slow":                func(p *proxyWriter, e *Entry) string { return strconv.FormatBool(e.Slow) },
+	"cache_status":        func(p *proxyWriter, e *Entry) string { return e.CacheStatus },
+	"upstream":            func(p *proxyWriter, e *Entry) string { return e.Upstream },
+	"req_content_type":    func(p *proxyWriter, e *Entry) string { return e.ReqContentType },
+	"req_size":            func(p *proxyWriter, e *Entry) string { return strconv.Itoa(e.ReqSize) },
+	"req_body_partial":    func(p *proxyWriter, e *Entry) string { return strconv.FormatBool(e.ReqBodyPartial) },
+	"req_size_declared":   func(p *proxyWriter, e *Entry) string { return strconv.FormatBool(e.ReqSizeDeclared) },
+	"req_body":            func(p *proxyWriter, e *Entry) string { return fmt.Sprint(p.reqBody(p.textBody)) },
+	"resp_content_type":   func(p *proxyWriter, e *Entry) string { return e.RespContentType },
+	"resp_size":           func(p *proxyWriter, e *Entry) string { return strconv.Itoa(e.RespSize) },
+	"req_hash":            func(p *proxyWriter, e *Entry) string { return hexSum(p.reqHash) },
+	"resp_hash":           func(p *proxyWriter, e *Entry) string { return hexSum(p.respHash) },
+	"resp_body":           func(p *proxyWriter, e *Entry) string { return fmt.Sprint(p.respBody(p.textBody)) },
+	"tls_version": func(p *proxyWriter, e *Entry) string {
+		if e.TLS == nil {
+			return ""
+		}
+		return tlsVersionName(e.TLS.Version)
+	},
+	"tls_cipher": func(p *proxyWriter, e *Entry) string {
+		if e.TLS == nil {
+			return ""
+		}
+		return tls.CipherSuiteName(e.TLS.CipherSuite)
+	},
+}
+
+// templateSegment 模板的一段, field 为空时是原样输出的文本
+type templateSegment struct {
+	text  string
+	field string
+}
+
+// parseTemplate 解析 {name} 形式的占位符
+// 不认识的占位符 strict 时报错, 否则作为文本原样输出
+func parseTemplate(tmpl string, strict bool) (segments []templateSegment, err error) {
+	for tmpl != "" {
+		start := strings.IndexByte(tmpl, '{')
+		end := strings.IndexByte(tmpl[start+1:], '}')
+		if start < 0 || end < 0 {
+			segments = append(segments, templateSegment{text: tmpl})
+			break
+		}
+		end += start + 1
+		if start > 0 {
+			segments = append(segments, templateSegment{text: tmpl[:start]})
+		}
+		name := tmpl[start+1 : end]
+		if _, ok := templateFields[name]; ok {
+			segments = append(segments, templateSegment{field: name})
+		} else if strict {
+			return nil, fmt.Errorf("unknown placeholder {%s}", name)
+		} else {
+			segments = append(segments, templateSegment{text: tmpl[start : end+1]})
+		}
+		tmpl = tmpl[end+1:]
+	}
+	return
+}
+
+// textBody 文本格式的 body
+func (p *proxyWriter) textBody(buf *bytes.Buffer) interface{} {
+	return p.tryToJson(buf)
+}
+
+func (p *proxyWriter) writeTemplateLog(e *Entry, w io.Writer) {
+	for _, seg := range p.z.template {
+		if seg.field == "" {
+			io.WriteString(w, seg.text)
+			continue
+		}
+		io.WriteString(w, templateFields[seg.field](p, e))
+	}
+	io.WriteString(w, p.z.lineSeparator())
+}
@@ -0,0 +1,67 @@
+package zlog
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// randomHex n 字节的随机数, 十六进制编码
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// traceID 从 TraceHeader 读取链路 id, 没有时生成一个
+// 生成的 id 同时写到请求头和响应头, 上游和客户端看到的是同一个 id
+func (z *ZLog) traceID(w http.ResponseWriter, r *http.Request) string {
+	if z.TraceHeader == "" {
+		return ""
+	}
+	id := r.Header.Get(z.TraceHeader)
+	if id == "" {
+		id = randomHex(16)
+		r.Header.Set(z.TraceHeader, id)
+	}
+	w.Header().Set(z.TraceHeader, id)
+	return id
+}
+
+// traceparentHeader W3C Trace Context 的请求头
+const traceparentHeader = "traceparent"
+
+// parseTraceparent 解析 version-traceid-spanid-flags, 全 0 的 id 不合法
+func parseTraceparent(v string) (traceID, spanID string, ok bool) {
+	parts := strings.Split(strings.TrimSpace(v), "-")
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" || len(parts[1]) != 32 || len(parts[2]) != 16 || len(parts[3]) != 2 {
+		return "", "", false
+	}
+	for _, part := range parts[:4] {
+		if _, err := hex.DecodeString(part); err != nil || strings.ToLower(part) != part {
+			return "", "", false
+		}
+	}
+	if strings.Trim(parts[1], "0") == "" || strings.Trim(parts[2], "0") == "" {
+		return "", "", false
+	}
+	return parts[1], parts[2], true
+}
+
+// otelIDs 开启 otel 时从 traceparent 读取 trace id 和 span id
+// 没有 traceparent 并且开启 otel_generate 时生成新的 id, 写到请求头里让上游加入同一条链路
+func (z *ZLog) otelIDs(r *http.Request) (traceID, spanID string) {
+	if !z.OTel && !z.OTelGenerate {
+		return "", ""
+	}
+	if traceID, spanID, ok := parseTraceparent(r.Header.Get(traceparentHeader)); ok {
+		return traceID, spanID
+	}
+	if !z.OTelGenerate {
+		return "", ""
+	}
+	traceID, spanID = randomHex(16), randomHex(8)
+	r.Header.Set(traceparentHeader, "00-"+traceID+"-"+spanID+"-01")
+	return traceID, spanID
+}
@@ -0,0 +1,45 @@
+package zlog
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// trailers 响应结束后下游设置的 trailer, 包括预先在 Trailer 头里声明的和用 http.TrailerPrefix 直接写的
+func (p *proxyWriter) trailers() http.Header {
+	if !p.z.LogTrailers || p.upgraded() {
+		return nil
+	}
+	// trailer 在响应头发出之后才写入, 要读底层的 Header 而不是快照
+	h := p.ResponseWriter.Header()
+	out := make(http.Header)
+	for _, v := range p.header().Values("Trailer") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				if values := h.Values(name); len(values) > 0 {
+					out[http.CanonicalHeaderKey(name)] = values
+				}
+			}
+		}
+	}
+	for k, values := range h {
+		if name, ok := strings.CutPrefix(k, http.TrailerPrefix); ok {
+			out[http.CanonicalHeaderKey(name)] = values
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// trailerFields 按名字排序并脱敏
+func (z *ZLog) trailerFields(trailers http.Header) []headerField {
+	names := make([]string, 0, len(trailers))
+	for name := range trailers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return z.pickHeaders(trailers, names)
+}
@@ -0,0 +1,162 @@
+package zlog
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// httpSinkRetries 发送失败后的重试次数, 仍然失败就丢弃这一批
+const httpSinkRetries = 2
+
+// httpSink 把日志攒批后 POST 到收集端, 请求体是 json 数组
+// 发送在后台 goroutine 里进行, 队列满时丢弃, 不会阻塞请求
+type httpSink struct {
+	url       string
+	client    *http.Client
+	batchSize int
+	interval  time.Duration
+	logger    *zap.Logger
+	// sep 行分隔符, 放进 json 数组之前去掉
+	sep []byte
+	// dropped 丢弃日志时回调, 用于统计
+	dropped func(n int)
+	// breaker 收集端连续失败时熔断, 不再重试
+	breaker *breaker
+
+	mu      sync.RWMutex
+	closed  bool
+	entries chan []byte
+	done    chan struct{}
+}
+
+func (z *ZLog) newHTTPSink() *httpSink {
+	s := &httpSink{
+		url:       z.HTTPSink,
+		batchSize: z.HTTPSinkBatch,
+		interval:  time.Duration(z.HTTPSinkFlush),
+		logger:    z.logger,
+		sep:       []byte(z.lineSeparator()),
+		dropped: func(n int) {
+			z.countDropped(n)
+		},
+		breaker: z.newBreaker(),
+		done:    make(chan struct{}),
+	}
+	timeout := time.Duration(z.HTTPSinkTimeout)
+	if timeout <= 0 {
+		timeout = DefaultHTTPSinkTimeout
+	}
+	if s.batchSize <= 0 {
+		s.batchSize = DefaultHTTPSinkBatch
+	}
+	if s.interval <= 0 {
+		s.interval = DefaultHTTPSinkFlush
+	}
+	s.client = &http.Client{Timeout: timeout}
+	s.entries = make(chan []byte, s.batchSize*10)
+	go s.run()
+	return s
+}
+
+// Write line 会被复用, 这里需要拷贝一份
+// 发送是异步的, 失败只会记录警告, 不返回错误
+func (s *httpSink) Write(line []byte, status int) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.closed {
+		return nil
+	}
+	select {
+	case s.entries <- append([]byte(nil), line...):
+	default:
+		s.dropped(1)
+		s.logger.Warn("zlog http sink queue full, dropping entry", zap.String("url", s.url))
+	}
+	return nil
+}
+
+func (s *httpSink) run() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	batch := make([][]byte, 0, s.batchSize)
+	for {
+		select {
+		case line, ok := <-s.entries:
+			if !ok {
+				s.flush(batch)
+				return
+			}
+			batch = append(batch, line)
+			if len(batch) >= s.batchSize {
+				s.flush(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			s.flush(batch)
+			batch = batch[:0]
+		}
+	}
+}
+
+// flush json 格式的日志直接嵌入, 文本格式作为字符串
+func (s *httpSink) flush(batch [][]byte) {
+	if len(batch) == 0 {
+		return
+	}
+	entries := make([]json.RawMessage, len(batch))
+	for i, line := range batch {
+		line = bytes.TrimSuffix(line, s.sep)
+		if json.Valid(line) {
+			entries[i] = line
+		} else {
+			entries[i] = marshalJSON(string(line))
+		}
+	}
+	if !s.breaker.allow() {
+		s.dropped(len(batch))
+		return
+	}
+	body := marshalJSON(entries)
+	var err error
+	for i := 0; i <= httpSinkRetries; i++ {
+		if err = s.post(body); err == nil {
+			break
+		}
+	}
+	s.breaker.done(err)
+	if err == nil {
+		return
+	}
+	s.dropped(len(batch))
+	s.logger.Warn("zlog http sink failed, dropping batch",
+		zap.String("url", s.url), zap.Int("entries", len(batch)), zap.Error(err))
+}
+
+func (s *httpSink) post(body []byte) error {
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Close 发送剩余的日志后返回
+func (s *httpSink) Close() error {
+	s.mu.Lock()
+	s.closed = true
+	close(s.entries)
+	s.mu.Unlock()
+	<-s.done
+	return nil
+}
@@ -0,0 +1,329 @@
+package zlog
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+// readerFromRecorder 底层 ResponseWriter 实现了 io.ReaderFrom, 走 ReadFrom 的透传路径
+type readerFromRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (r readerFromRecorder) ReadFrom(src io.Reader) (int64, error) {
+	return io.Copy(r.ResponseRecorder, src)
+}
+
+// body 超过截断大小时分多次 Read 和 Write, 缓存的内容停在截断处, 不会 panic
+func TestCaptureTruncation(t *testing.T) {
+	tests := []struct {
+		name     string
+		truncate uint64
+		size     int
+		chunk    int
+	}{
+		{"smaller than truncate", 64, 40, 7},
+		{"exactly truncate", 64, 64, 16},
+		{"one chunk over", 64, 80, 80},
+		{"many chunks over", 64, 1000, 7},
+		{"chunk larger than truncate", 8, 100, 50},
+		{"one byte chunks", 8, 20, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			z := &ZLog{Format: FormatJSON, Truncate: tt.truncate}
+			sink := provisionTest(t, z)
+			body := strings.Repeat("a", tt.size)
+			r := newTestRequest("POST", "/", strings.NewReader(body))
+			r.Header.Set("Content-Type", "text/plain")
+			err := serveTest(z, httptest.NewRecorder(), r, func(w http.ResponseWriter, r *http.Request) error {
+				buf := make([]byte, tt.chunk)
+				for {
+					n, err := r.Body.Read(buf)
+					if _, werr := w.Write(buf[:n]); werr != nil {
+						return werr
+					}
+					if err == io.EOF {
+						return nil
+					}
+					if err != nil {
+						return err
+					}
+				}
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := body
+			if tt.size > int(tt.truncate) {
+				want = body[:tt.truncate] + "...[truncated, total " + strconv.Itoa(tt.size) + " bytes]"
+			}
+			m := decodeEntry(t, sink.lines()[0])
+			for _, dir := range []string{"req", "resp"} {
+				if m[dir+"_size"] != float64(tt.size) {
+					t.Errorf("%s_size = %v", dir, m[dir+"_size"])
+				}
+				if got, _ := m[dir+"_body"].(string); got != want {
+					t.Errorf("%s_body = %q, want %q", dir, got, want)
+				}
+			}
+		})
+	}
+}
+
+// 多次 Write 和 Flush 仍然只写一条日志, 响应体是所有 Write 拼起来的内容
+func TestMultipleWritesOneEntry(t *testing.T) {
+	z := &ZLog{}
+	sink := provisionTest(t, z)
+	rec := httptest.NewRecorder()
+	err := serveTest(z, rec, newTestRequest("GET", "/", nil), func(w http.ResponseWriter, r *http.Request) error {
+		io.WriteString(w, "hello ")
+		w.(http.Flusher).Flush()
+		io.WriteString(w, "world")
+		w.(http.Flusher).Flush()
+		_, err := io.WriteString(w, "!")
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rec.Flushed || rec.Body.String() != "hello world!" {
+		t.Fatalf("client got %q, flushed %v", rec.Body.String(), rec.Flushed)
+	}
+	out := sink.String()
+	if strings.Count(out, "\n") != 1 || !strings.HasSuffix(out, "\n") {
+		t.Fatalf("want one newline terminated entry, got %q", out)
+	}
+	if !strings.Contains(out, "hello world!") {
+		t.Fatalf("entry missing concatenated body: %q", out)
+	}
+}
+
+// io.Copy 走 ReadFrom, ResponseController 通过 Unwrap 找到 Flush, 记录的大小和内容都和写出的一致
+func TestReadFromAndResponseController(t *testing.T) {
+	body := strings.Repeat("0123456789", 10)
+	tests := []struct {
+		name     string
+		truncate uint64
+		wrap     func(*httptest.ResponseRecorder) http.ResponseWriter
+	}{
+		{"recorder", 1024, func(r *httptest.ResponseRecorder) http.ResponseWriter { return r }},
+		{"reader from", 1024, func(r *httptest.ResponseRecorder) http.ResponseWriter { return readerFromRecorder{r} }},
+		{"reader from truncated", 16, func(r *httptest.ResponseRecorder) http.ResponseWriter { return readerFromRecorder{r} }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			z := &ZLog{Format: FormatJSON, Truncate: tt.truncate}
+			sink := provisionTest(t, z)
+			rec := httptest.NewRecorder()
+			err := serveTest(z, tt.wrap(rec), newTestRequest("GET", "/", nil), func(w http.ResponseWriter, r *http.Request) error {
+				w.Header().Set("Content-Type", "text/plain")
+				if _, err := io.Copy(w, strings.NewReader(body)); err != nil {
+					return err
+				}
+				return http.NewResponseController(w).Flush()
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !rec.Flushed || rec.Body.String() != body {
+				t.Fatalf("client got %q, flushed %v", rec.Body.String(), rec.Flushed)
+			}
+			lines := sink.lines()
+			if len(lines) != 1 {
+				t.Fatalf("want one entry, got %q", lines)
+			}
+			m := decodeEntry(t, lines[0])
+			if m["resp_size"] != float64(len(body)) {
+				t.Errorf("resp_size = %v, want %d", m["resp_size"], len(body))
+			}
+			respBody, _ := m["resp_body"].(string)
+			want := body
+			if len(want) > int(tt.truncate) {
+				want = want[:tt.truncate]
+			}
+			if !strings.HasPrefix(respBody, want) {
+				t.Errorf("resp_body = %q, want prefix %q", respBody, want)
+			}
+		})
+	}
+}
+
+// 声明的 Content-Length 超过 max_body_log 时不缓存响应体, 分块传输的响应按截断大小缓存
+func TestMaxBodyLog(t *testing.T) {
+	body := strings.Repeat("a", 64)
+	tests := []struct {
+		name     string
+		declared bool
+		want     string
+	}{
+		{"large content length", true, ""},
+		{"chunked", false, strings.Repeat("a", 8) + "...[truncated, total 64 bytes]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			z := &ZLog{Format: FormatJSON, Truncate: 8, MaxBodyLog: 16}
+			sink := provisionTest(t, z)
+			rec := httptest.NewRecorder()
+			err := serveTest(z, rec, newTestRequest("GET", "/", nil), func(w http.ResponseWriter, r *http.Request) error {
+				if tt.declared {
+					w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+				}
+				for i := 0; i < len(body); i += 16 {
+					if _, err := io.WriteString(w, body[i:i+16]); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.Body.String() != body {
+				t.Fatalf("client got %q", rec.Body.String())
+			}
+			m := decodeEntry(t, sink.lines()[0])
+			if m["resp_size"] != float64(len(body)) {
+				t.Errorf("resp_size = %v", m["resp_size"])
+			}
+			if got, _ := m["resp_body"].(string); got != tt.want {
+				t.Errorf("resp_body = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// 101 之后接管连接, 日志记录 101 和切换到的协议, 不记录响应体
+func TestSwitchingProtocolsHijack(t *testing.T) {
+	z := &ZLog{Format: FormatJSON}
+	sink := provisionTest(t, z)
+	handled := make(chan error, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handled <- serveTest(z, w, withTestContext(r), func(w http.ResponseWriter, r *http.Request) error {
+			w.Header().Set("Connection", "Upgrade")
+			w.Header().Set("Upgrade", "h2c")
+			w.WriteHeader(http.StatusSwitchingProtocols)
+			conn, rw, err := w.(http.Hijacker).Hijack()
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			rw.WriteString("new protocol data")
+			return rw.Flush()
+		})
+	}))
+	defer srv.Close()
+
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "GET / HTTP/1.1\r\nHost: example.com\r\nConnection: Upgrade\r\nUpgrade: h2c\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("client got status %d", resp.StatusCode)
+	}
+	// 101 的响应没有 body, 之后的数据要直接从连接上读
+	if data, _ := io.ReadAll(br); string(data) != "new protocol data" {
+		t.Fatalf("client got %q after upgrade", data)
+	}
+	if err := <-handled; err != nil {
+		t.Fatal(err)
+	}
+
+	lines := sink.lines()
+	if len(lines) != 1 {
+		t.Fatalf("want one entry, got %q", lines)
+	}
+	m := decodeEntry(t, lines[0])
+	if m["status"] != float64(http.StatusSwitchingProtocols) || m["upgrade"] != "h2c" {
+		t.Errorf("status = %v upgrade = %v", m["status"], m["upgrade"])
+	}
+	if _, ok := m["resp_body"]; ok {
+		t.Errorf("resp_body captured after upgrade: %v", m["resp_body"])
+	}
+}
+
+// discardWriter 实现了 io.ReaderFrom 的 ResponseWriter, 相当于 sendfile 的快速路径, 只丢弃数据
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) WriteHeader(int)             {}
+func (w *discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w *discardWriter) ReadFrom(r io.Reader) (int64, error) {
+	return io.Copy(io.Discard, r)
+}
+
+// 大文件经过 ReadFrom 透传, direct 是不经过 zlog 的基线
+func BenchmarkServeLargeFile(b *testing.B) {
+	const size = 8 << 20
+	f, err := os.CreateTemp(b.TempDir(), "static-*")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Write(bytes.Repeat([]byte("0123456789abcdef"), size/16)); err != nil {
+		b.Fatal(err)
+	}
+	serve := func(declared bool) caddyhttp.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) error {
+			if declared {
+				w.Header().Set("Content-Length", strconv.Itoa(size))
+			}
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			n, err := io.Copy(w, f)
+			if err == nil && n != size {
+				err = io.ErrShortWrite
+			}
+			return err
+		}
+	}
+	b.Run("direct", func(b *testing.B) {
+		h := serve(true)
+		b.SetBytes(size)
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := h(&discardWriter{header: http.Header{}}, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	for _, declared := range []bool{true, false} {
+		name := "chunked"
+		if declared {
+			name = "content_length"
+		}
+		b.Run(name, func(b *testing.B) {
+			z := &ZLog{}
+			provisionTest(b, z)
+			h := serve(declared)
+			b.SetBytes(size)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := serveTest(z, &discardWriter{header: http.Header{}}, newTestRequest("GET", "/static", nil), h); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}